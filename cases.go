@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// defaultCaseVariable is the variable holding the row when cases does not name it.
+const defaultCaseVariable = "row"
+
+// Cases runs the invoke once per row, the row is a variable of its data,
+// headers and expects and every run is reported as name[row name]:
+//
+//	invoke create local users.Users Create cases user in [
+//	  {name: "alice", age: 30},
+//	  {name: "bob", age: 40}
+//	] by name data {
+//	  name: user.name
+//	}
+//
+// Rows are a list, a variable holding one or a CSV or JSON file, cases file
+// "users.csv", relative to the .trpc file of the invoke.
+type Cases struct {
+	Pos lexer.Position
+
+	Var    string `"cases" ( @Ident "in" )?`
+	File   *Value `( "file" @@`
+	Rows   *Value `| @@ )`
+	NameBy string `( "by" @Ident )?`
+}
+
+// caseInvokes returns a copy of the invoke for every row of its cases.
+func (run *testRun) caseInvokes(invoke *Invoke) []*Invoke {
+	cases := invoke.Cases
+	rows := run.caseRows(cases)
+	variable := cases.Var
+	if variable == "" {
+		variable = defaultCaseVariable
+	}
+	invokes := make([]*Invoke, len(rows))
+	for i, row := range rows {
+		variables := make(Variables, len(run.variables)+1)
+		for name, value := range run.variables {
+			variables[name] = value
+		}
+		variables[variable] = row
+		name := fmt.Sprintf("%s[%s]", invoke.Name, run.caseName(cases, row, i))
+		invokes[i] = invoke.forCase(name, variables)
+	}
+	return invokes
+}
+
+// caseName names a row by the field of "by", otherwise by its name field, a
+// scalar row by its value and any other row by its position.
+func (run *testRun) caseName(cases *Cases, row any, i int) string {
+	fields, isObject := row.(map[string]any)
+	if cases.NameBy != "" {
+		name, ok := fields[cases.NameBy]
+		if !ok {
+			syntaxError(run.mainEntery.Lines, cases.Pos, 0, "Row %d of cases has no field %s to be named by", i, cases.NameBy)
+		}
+		return fmt.Sprint(name)
+	}
+	if name, ok := fields["name"]; ok && name != nil {
+		return fmt.Sprint(name)
+	}
+	if _, isList := row.([]any); !isObject && !isList && row != nil {
+		return fmt.Sprint(row)
+	}
+	return strconv.Itoa(i)
+}
+
+// caseRows evaluates the rows of cases, listed in the file or read from a file.
+func (run *testRun) caseRows(cases *Cases) []any {
+	lines := run.mainEntery.Lines
+	if cases.File == nil {
+		rows, haveReference := cases.Rows.value(lines, &run.namedInvokes, run.variables, false)
+		if haveReference {
+			syntaxError(lines, cases.Rows.Pos, 0, "Cases can not reference invokes")
+		}
+		list, ok := rows.([]any)
+		if !ok {
+			syntaxError(lines, cases.Rows.Pos, 0, "Cases must be a list of rows but got %s", cases.Rows.source())
+		}
+		return list
+	}
+
+	file, haveReference := cases.File.value(lines, &run.namedInvokes, run.variables, false)
+	path, ok := file.(string)
+	if !ok || haveReference {
+		syntaxError(lines, cases.File.Pos, 0, "Cases file must be a string but got %v", file)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(cases.Pos.Filename), path)
+	}
+	var rows []any
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readCSVRows(path)
+	case ".json":
+		rows, err = readJSONRows(path)
+	default:
+		syntaxError(lines, cases.File.Pos, 0, "Cases file %s must be a .csv or .json file", path)
+	}
+	if err != nil {
+		syntaxError(lines, cases.File.Pos, 0, "Can not read cases from %s: %v", path, err)
+	}
+	return rows
+}
+
+// readCSVRows reads the rows of a CSV file with a header line, each row is an
+// object by the names of the header.
+func readCSVRows(path string) ([]any, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the header line is missing")
+	}
+	header := records[0]
+	rows := make([]any, len(records)-1)
+	for i, record := range records[1:] {
+		row := make(map[string]any, len(header))
+		for j, cell := range record {
+			row[header[j]] = csvValue(cell)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// csvValue makes a cell look like a value of a .trpc file, an empty cell is
+// null and a number or bool is only converted when it is written in its
+// plain form, so "007" stays a string.
+func csvValue(cell string) any {
+	switch cell {
+	case "":
+		return nil
+	case "true", "false":
+		return cell == "true"
+	}
+	if n, err := strconv.ParseInt(cell, 10, 64); err == nil && strconv.FormatInt(n, 10) == cell {
+		return n
+	}
+	if f, err := strconv.ParseFloat(cell, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == cell {
+		return f
+	}
+	return cell
+}
+
+func readJSONRows(path string) ([]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rows []any
+	if err := json.Unmarshal(content, &rows); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		rows[i] = fromJSON(row)
+	}
+	return rows, nil
+}
+
+// forCase copies the invoke for a row of its cases, expects are copied as
+// well since their paths are rewritten while the invoke is checked.
+func (invoke Invoke) forCase(name string, variables Variables) *Invoke {
+	invoke.Name = name
+	invoke.Cases = nil
+	invoke.Variables = variables
+	invoke.SourceExpects = copyExpects(invoke.SourceExpects)
+	exchange := make([]*Exchange, len(invoke.Exchange))
+	for i, step := range invoke.Exchange {
+		stepCopy := *step
+		stepCopy.Expects = copyExpects(step.Expects)
+		exchange[i] = &stepCopy
+	}
+	if invoke.Exchange != nil {
+		invoke.Exchange = exchange
+	}
+	if invoke.Retry != nil {
+		retry := *invoke.Retry
+		retry.Until = copyExpects(retry.Until)
+		invoke.Retry = &retry
+	}
+	return &invoke
+}
+
+func copyExpects(expects []*Expect) []*Expect {
+	copied := make([]*Expect, len(expects))
+	for i, expect := range expects {
+		expectCopy := *expect
+		path := *expect.Path
+		path.Parts = append([]Part{}, expect.Path.Parts...)
+		expectCopy.Path = &path
+		copied[i] = &expectCopy
+	}
+	return copied
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVValue(t *testing.T) {
+	for _, test := range []struct {
+		cell string
+		want any
+	}{
+		{"", nil},
+		{"true", true},
+		{"false", false},
+		{"True", "True"},
+		{"42", int64(42)},
+		{"-7", int64(-7)},
+		{"007", "007"},
+		{"+1", "+1"},
+		{"1.5", 1.5},
+		{"1.50", "1.50"},
+		{"1e3", "1e3"},
+		{"alice", "alice"},
+		{" 1", " 1"},
+	} {
+		if got := csvValue(test.cell); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("csvValue(%q) = %#v, want %#v", test.cell, got, test.want)
+		}
+	}
+}
+
+// caseSource is a file with an invoke of the cases and data.
+func caseSource(cases, data string) string {
+	return testHeader + `
+endpoint local "127.0.0.1" port 50051
+invoke call local pkg.Service Method ` + cases + ` data { ` + data + ` }
+`
+}
+
+func TestCaseRows(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cases    string
+		variable string
+		rows     []any
+	}{
+		{
+			name:     "csv",
+			cases:    `cases user in file "users.csv"`,
+			variable: "user",
+			rows: []any{
+				map[string]any{"name": "alice", "age": int64(30), "admin": true, "note": nil},
+				map[string]any{"name": "bob", "age": "040", "admin": false, "note": "x"},
+			},
+		},
+		{
+			name:     "json relative to the file",
+			cases:    `cases file "cases/ids.json"`,
+			variable: defaultCaseVariable,
+			rows: []any{
+				map[string]any{"id": int64(1), "tags": []any{"a"}},
+				map[string]any{"id": 2.5},
+			},
+		},
+		{
+			name:     "list",
+			cases:    `cases id in [1, "b", true]`,
+			variable: "id",
+			rows:     []any{int64(1), "b", true},
+		},
+	} {
+		dir := writeFiles(t, map[string]string{
+			"a.trpc":         caseSource(test.cases, "v: "+test.variable),
+			"users.csv":      "name,age,admin,note\nalice,30,true,\nbob,040,false,x\n",
+			"cases/ids.json": `[{"id": 1, "tags": ["a"]}, {"id": 2.5}]`,
+		})
+		run := loadFile(t, filepath.Join(dir, "a.trpc"), "")
+		if len(run.invokeOrder) != len(test.rows) {
+			t.Fatalf("%s: invokes %v, want %d", test.name, run.invokeOrder, len(test.rows))
+		}
+		for i, name := range run.invokeOrder {
+			if got := run.namedInvokes[name].Variables[test.variable]; !reflect.DeepEqual(got, test.rows[i]) {
+				t.Errorf("%s: row %d = %#v, want %#v", test.name, i, got, test.rows[i])
+			}
+		}
+	}
+}
+
+func TestCaseRowErrors(t *testing.T) {
+	for _, test := range []struct {
+		cases string
+		err   string
+	}{
+		{`cases file "missing.csv"`, "Can not read cases from"},
+		{`cases file "bad.json"`, "Can not read cases from"},
+		{`cases file "rows.yaml"`, "must be a .csv or .json file"},
+		{`cases file "empty.csv"`, "the header line is missing"},
+		{`cases file "ragged.csv"`, "wrong number of fields"},
+		{`cases file 1`, "Cases file must be a string but got 1"},
+		{`cases "a"`, `Cases must be a list of rows but got "a"`},
+		{`cases [{id: 1}] by name`, "Row 0 of cases has no field name to be named by"},
+	} {
+		dir := writeFiles(t, map[string]string{
+			"a.trpc":     caseSource(test.cases, ""),
+			"bad.json":   `{"id": 1}`,
+			"rows.yaml":  "- id: 1\n",
+			"empty.csv":  "",
+			"ragged.csv": "a,b\n1\n",
+		})
+		err := recoverError(t, func() { loadFile(t, filepath.Join(dir, "a.trpc"), "") })
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.cases, err, test.err)
+		}
+	}
+}
+
+func TestCaseName(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.trpc": testHeader + `
+endpoint local "127.0.0.1" port 50051
+let USERS = [{name: "alice", id: 1}, {name: "bob", id: 2}]
+invoke byName local pkg.Service Method cases USERS data { }
+invoke byField local pkg.Service Method cases USERS by id data { }
+invoke byValue local pkg.Service Method cases ["x", 2, 1.5] data { }
+invoke byPosition local pkg.Service Method cases [{id: 1}, [1], {name: "c"}] data { }
+`,
+	})
+	run := loadFile(t, filepath.Join(dir, "a.trpc"), "")
+	want := []string{
+		"byName[alice]", "byName[bob]",
+		"byField[1]", "byField[2]",
+		"byValue[x]", "byValue[2]", "byValue[1.5]",
+		"byPosition[0]", "byPosition[1]", "byPosition[c]",
+	}
+	if !reflect.DeepEqual(run.invokeOrder, want) {
+		t.Errorf("invokes %v, want %v", run.invokeOrder, want)
+	}
+}
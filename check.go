@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trpc/grpcrunner"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/fatih/color"
+	"github.com/fullstorydev/grpcurl"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+)
+
+type checkCmd struct {
+	Files   []string `required existing file arg help:"TRPC(Test RPC) file(s) to check."`
+	Profile string   `help:"Environment profile which overrides variables and endpoints, as for run."`
+}
+
+func (cmd *checkCmd) Run() error {
+	problems := 0
+	for _, file := range cmd.Files {
+		trpc, err := parseFile(file)
+		if err != nil {
+			fmt.Println(err)
+			problems++
+			continue
+		}
+		if len(trpc.Entries) > 0 {
+			problems += checkFile(trpc, cmd.Profile)
+		}
+	}
+	if problems > 0 {
+		return trpcError{ExitCode: 2, Msg: fmt.Sprintf("Found %d problem(s)", problems)}
+	}
+	color.Green("No problems found")
+	return nil
+}
+
+// checker validates the invokes of a file against the descriptors of its
+// imports, a problem is reported once even when cases repeat its invoke.
+type checker struct {
+	run      *testRun
+	source   grpcurl.DescriptorSource
+	problems int
+	reported map[string]bool
+}
+
+// checkFile loads the file as run does, without calling any RPC, and checks
+// its invokes. It returns the number of problems found.
+func checkFile(trpc *Trpc, profile string) (problems int) {
+	mainEntery := trpc.Entries[0]
+	run := newTestRun(mainEntery)
+	defer func() {
+		// syntaxError has reported the problem which stopped loading the file
+		if err := recoveredError(recover()); err != nil {
+			problems++
+		}
+	}()
+	run.load(trpc, profile)
+
+	source, err := grpcrunner.FileSource(grpcrunner.RunParams{
+		Protoset:    run.protoSets,
+		ImportPaths: run.protoImportPaths,
+		ProtoFiles:  run.protoFiles,
+	})
+	if err != nil {
+		syntaxError(mainEntery.Lines, mainEntery.Pos, 0, "%v", err)
+	}
+	if source == nil {
+		color.Yellow("%s imports no protofile or protoset, its services are only known by reflection so their invokes are not checked", mainEntery.Pos.Filename)
+		return 0
+	}
+	check := &checker{run: run, source: source, reported: make(map[string]bool)}
+	for _, order := range [][]string{run.setupOrder, run.invokeOrder, run.teardownOrder} {
+		for _, invokeName := range order {
+			check.invoke(run.namedInvokes[invokeName])
+		}
+	}
+	return check.problems
+}
+
+// problem reports what is wrong at pos with a caret under token, or under pos
+// when the token is not found on its line.
+func (check *checker) problem(pos lexer.Position, token string, msg string, a ...any) {
+	key := fmt.Sprintf("%s:%d:%d %s", pos.Filename, pos.Line, pos.Column, fmt.Sprintf(msg, a...))
+	if check.reported[key] {
+		return
+	}
+	check.reported[key] = true
+	check.problems++
+	lines := check.lines(pos)
+	offset := pos.Column - 1
+	if token != "" {
+		offset = tokenOffset(lines, pos, token)
+	}
+	color.Red("Problem:")
+	fatalLine(lines, pos, offset, true, msg, a...)
+}
+
+func (check *checker) lines(pos lexer.Position) *[]string {
+	if fileLines, ok := sourceLines[pos.Filename]; ok {
+		return fileLines
+	}
+	return check.run.mainEntery.Lines
+}
+
+// tokenOffset is where token is on the line of pos at or after pos, pos when
+// it is not there.
+func tokenOffset(lines *[]string, pos lexer.Position, token string) int {
+	offset := pos.Column - 1
+	if pos.Line > len(*lines) || offset > len((*lines)[pos.Line-1]) {
+		return offset
+	}
+	if i := strings.Index((*lines)[pos.Line-1][offset:], token); i >= 0 {
+		offset += i
+	}
+	return offset
+}
+
+func (check *checker) invoke(invoke *Invoke) {
+	symbol, err := check.source.FindSymbol(invoke.Service)
+	service, isService := symbol.(*desc.ServiceDescriptor)
+	if err != nil || !isService {
+		services, _ := check.source.ListServices()
+		check.problem(invoke.Pos, invoke.Service, "Service %s not found, known services are %s", invoke.Service, strings.Join(services, ", "))
+		return
+	}
+	method := service.FindMethodByName(invoke.RPC)
+	if method == nil {
+		names := make([]string, 0)
+		for _, known := range service.GetMethods() {
+			names = append(names, known.GetName())
+		}
+		// the RPC name follows the service
+		rpcPos := invoke.Pos
+		rpcPos.Column = tokenOffset(check.lines(invoke.Pos), invoke.Pos, invoke.Service) + len(invoke.Service) + 1
+		check.problem(rpcPos, invoke.RPC, "RPC %s not found on %s, known RPCs are %s", invoke.RPC, invoke.Service, strings.Join(names, ", "))
+		return
+	}
+	if invoke.Stream != nil && !method.IsClientStreaming() {
+		check.problem(invoke.Stream.Pos, "", "%s is not a client streaming RPC and can not have a stream", invoke.RPC)
+	}
+	if invoke.Exchange != nil && !(method.IsClientStreaming() && method.IsServerStreaming()) {
+		check.problem(invoke.Pos, "", "%s is not a bidirectional streaming RPC and can not have an exchange", invoke.RPC)
+	}
+
+	input := method.GetInputType()
+	for _, data := range invoke.Data {
+		check.field(data.Pos, data.Key, &data.Value, input)
+	}
+	if invoke.Stream != nil {
+		for _, message := range invoke.Stream.Elements {
+			if message.Map != nil {
+				check.message(message.Map, input)
+			}
+		}
+	}
+	for _, step := range invoke.Exchange {
+		check.message(step.Send, input)
+	}
+
+	output := method.GetOutputType()
+	for i := range invoke.Expects {
+		expect := &invoke.Expects[i]
+		parts := expect.Path.Parts
+		if parts[0].Obj == "response" || parts[0].Obj == "responses" {
+			check.path(expect, parts[1:], output)
+		}
+	}
+}
+
+func (check *checker) message(value *Map, md *desc.MessageDescriptor) {
+	for _, entry := range value.Entries {
+		check.field(entry.Pos, entry.Key, &entry.Value, md)
+	}
+}
+
+// field checks the key of data or of an object is a field of md and its value
+// fits the field, by its proto or JSON name as the request parser takes both.
+func (check *checker) field(pos lexer.Position, key string, value *Value, md *desc.MessageDescriptor) {
+	field := md.FindFieldByName(key)
+	if field == nil {
+		field = md.FindFieldByJSONName(key)
+	}
+	if field == nil {
+		check.problem(pos, key, "Field %s not found on %s, available fields are %s", key, md.GetFullyQualifiedName(), fieldsOf(md))
+		return
+	}
+	check.value(value, field, false)
+}
+
+// value checks the value fits the field, values only known at run time such
+// as references and env() are not checked.
+func (check *checker) value(value *Value, field *desc.FieldDescriptor, item bool) {
+	if value.Reference != nil || value.Env != nil {
+		return
+	}
+	name := field.GetName()
+	if field.IsMap() {
+		if value.Map == nil {
+			check.problem(value.Pos, "", "Field %s is a map and expects an object", name)
+			return
+		}
+		for _, entry := range value.Map.Entries {
+			check.value(&entry.Value, field.GetMapValueType(), false)
+		}
+		return
+	}
+	if field.IsRepeated() && !item {
+		if value.Array == nil {
+			check.problem(value.Pos, "", "Field %s is repeated and expects a list", name)
+			return
+		}
+		for _, element := range value.Array.Elements {
+			check.value(element, field, true)
+		}
+		return
+	}
+	if value.Array != nil && !listMessage(field) {
+		check.problem(value.Pos, "", "Field %s is not repeated and can not be a list", name)
+		return
+	}
+
+	switch field.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		check.messageValue(value, field)
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		enum := field.GetEnumType()
+		if value.String != nil {
+			symbol, _ := strconv.Unquote(*value.String)
+			if enum.FindValueByName(symbol) == nil {
+				check.problem(value.Pos, "", "%s is not a value of %s, values are %s", *value.String, enum.GetFullyQualifiedName(), enumValuesOf(enum))
+			}
+		} else if value.Int == nil {
+			check.problem(value.Pos, "", "Field %s is an enum and expects the name or number of a value of %s", name, enum.GetFullyQualifiedName())
+		}
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		if value.Bool == nil {
+			check.problem(value.Pos, "", "Field %s is a bool and expects true or false", name)
+		}
+	case dpb.FieldDescriptorProto_TYPE_STRING, dpb.FieldDescriptorProto_TYPE_BYTES:
+		if value.String == nil {
+			check.problem(value.Pos, "", "Field %s is of %s and expects a string", name, typeNameOf(field))
+		}
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE, dpb.FieldDescriptorProto_TYPE_FLOAT:
+		if value.Float == nil && value.Int == nil && !check.numberString(value, false) {
+			check.problem(value.Pos, "", "Field %s is of %s and expects a number", name, typeNameOf(field))
+		}
+	default:
+		if value.Int == nil && !check.numberString(value, true) {
+			check.problem(value.Pos, "", "Field %s is of %s and expects an integer", name, typeNameOf(field))
+		}
+	}
+}
+
+// numberString tells the value is a string holding a number, which the
+// request parser takes for numeric fields.
+func (check *checker) numberString(value *Value, integer bool) bool {
+	if value.String == nil {
+		return false
+	}
+	str, _ := strconv.Unquote(*value.String)
+	if integer {
+		_, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			_, err = strconv.ParseUint(str, 10, 64)
+		}
+		return err == nil
+	}
+	switch str {
+	case "NaN", "Infinity", "-Infinity":
+		return true
+	}
+	_, err := strconv.ParseFloat(str, 64)
+	return err == nil
+}
+
+// messageValue checks a value of a message field, well known types take their
+// canonical JSON form.
+func (check *checker) messageValue(value *Value, field *desc.FieldDescriptor) {
+	md := field.GetMessageType()
+	switch md.GetFullyQualifiedName() {
+	case "google.protobuf.Timestamp":
+		if value.String == nil && !value.Now {
+			check.problem(value.Pos, "", "Field %s is a Timestamp and expects now() or a string such as \"2006-01-02T15:04:05Z\"", field.GetName())
+		}
+		return
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		if value.String == nil {
+			check.problem(value.Pos, "", "Field %s is a %s and expects a string", field.GetName(), md.GetName())
+		}
+		return
+	case "google.protobuf.Struct", "google.protobuf.Value", "google.protobuf.ListValue", "google.protobuf.Any", "google.protobuf.Empty":
+		return
+	}
+	if wrapped := wrappedField(md); wrapped != nil {
+		check.value(value, wrapped, false)
+		return
+	}
+	if value.Map == nil {
+		check.problem(value.Pos, "", "Field %s is a %s and expects an object", field.GetName(), md.GetFullyQualifiedName())
+		return
+	}
+	check.message(value.Map, md)
+}
+
+// listMessage tells the field is a google.protobuf.ListValue or Value, which
+// take a list.
+func listMessage(field *desc.FieldDescriptor) bool {
+	md := field.GetMessageType()
+	if md == nil {
+		return false
+	}
+	name := md.GetFullyQualifiedName()
+	return name == "google.protobuf.ListValue" || name == "google.protobuf.Value"
+}
+
+// wrappedField is the value field of a wrapper such as google.protobuf.Int32Value.
+func wrappedField(md *desc.MessageDescriptor) *desc.FieldDescriptor {
+	name := md.GetFullyQualifiedName()
+	if !strings.HasPrefix(name, "google.protobuf.") || !strings.HasSuffix(name, "Value") {
+		return nil
+	}
+	return md.FindFieldByName("value")
+}
+
+// path checks the fields of a response path, which are by their proto names,
+// against the output message. Only the part after ".." is not checked.
+func (check *checker) path(expect *Expect, parts []Part, md *desc.MessageDescriptor) {
+	at := md.GetFullyQualifiedName()
+	for i, part := range parts {
+		if part.Recursive {
+			return
+		}
+		if md == nil {
+			check.problem(expect.Pos, part.Obj, "%s is not a message and has no field %s", at, part.Obj)
+			return
+		}
+		if wellKnownMessage(md) {
+			return
+		}
+		field := md.FindFieldByName(part.Obj)
+		if field == nil {
+			check.problem(expect.Pos, part.Obj, "Field %s not found on %s, available fields are %s", part.Obj, md.GetFullyQualifiedName(), fieldsOf(md))
+			return
+		}
+		at = part.Obj
+		isList := field.IsRepeated() && !field.IsMap()
+		element := field
+		if field.IsMap() {
+			element = field.GetMapValueType()
+		}
+		switch {
+		case len(part.Acc) == 0:
+			if (isList || field.IsMap()) && i < len(parts)-1 {
+				check.problem(expect.Pos, part.Obj, "Field %s holds many values, pick them by %s[0] or %s[*]", part.Obj, part.Obj, part.Obj)
+				return
+			}
+		case !isList && !field.IsMap():
+			if part.Acc[0].Wildcard || part.Acc[0].Filter != nil {
+				// the values of the fields of a message
+				return
+			}
+			check.problem(expect.Pos, part.Obj, "Field %s is not a list or map and can not be indexed", part.Obj)
+			return
+		case isList && part.Acc[0].StrIndex != nil:
+			check.problem(expect.Pos, part.Obj, "Field %s is a list and its index must be a number", part.Obj)
+			return
+		case len(part.Acc) > 1:
+			// a list or map item is never a list itself
+			return
+		}
+		md = element.GetMessageType()
+		if len(part.Acc) == 0 && (isList || field.IsMap()) {
+			md = nil
+		}
+	}
+}
+
+func wellKnownMessage(md *desc.MessageDescriptor) bool {
+	return strings.HasPrefix(md.GetFullyQualifiedName(), "google.protobuf.")
+}
+
+func fieldsOf(md *desc.MessageDescriptor) string {
+	names := make([]string, 0)
+	for _, field := range md.GetFields() {
+		names = append(names, field.GetName())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func enumValuesOf(enum *desc.EnumDescriptor) string {
+	names := make([]string, 0)
+	for _, value := range enum.GetValues() {
+		names = append(names, value.GetName())
+	}
+	return strings.Join(names, ", ")
+}
+
+// typeNameOf is the type of the field as it is written in a .proto file.
+func typeNameOf(field *desc.FieldDescriptor) string {
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const checkProto = `syntax = "proto3";
+package users;
+
+import "google/protobuf/struct.proto";
+
+message User {
+  string name = 1;
+  int32 age = 2;
+}
+
+message GetRequest {
+  string id = 1;
+  int32 limit = 2;
+  google.protobuf.ListValue values = 3;
+}
+
+service Users {
+  rpc Get(GetRequest) returns (User);
+}
+`
+
+// checkFiles writes the files and checks a.trpc as check does, it returns
+// the number of problems and what was printed.
+func checkFiles(t *testing.T, files map[string]string) (int, string, string) {
+	t.Helper()
+	dir := writeFiles(t, files)
+	path := filepath.Join(dir, "a.trpc")
+	trpc, err := parseFile(path)
+	if err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	var problems int
+	printed := captureOutput(t, func() { problems = checkFile(trpc, "") })
+	return problems, printed, path
+}
+
+func TestCheckProblems(t *testing.T) {
+	source := testHeader + `
+importpath "%s"
+import protofile "users.proto"
+endpoint local "127.0.0.1" port 50051
+invoke service local users.Nope Get data { id: "1" }
+invoke rpc local users.Users Find data { id: "1" }
+invoke key local users.Users Get data {
+nope: "1"
+}
+invoke scalar local users.Users Get data { id: "1", limit: "ten" }
+invoke list local users.Users Get data { id: "1", values: [1, "a"] }
+invoke path local users.Users Get data { id: "1" } expects {
+  response.nam isEmpty()
+  response.name isEmpty()
+}
+`
+	source = fmt.Sprintf(source, writeFiles(t, map[string]string{"users.proto": checkProto}))
+	problems, printed, path := checkFiles(t, map[string]string{"a.trpc": source})
+	lines := strings.Split(source, "\n")
+	for _, test := range []struct {
+		line  string
+		token string
+		msg   string
+	}{
+		{`invoke service local users.Nope Get data { id: "1" }`, "users.Nope", "Service users.Nope not found, known services are users.Users"},
+		{`invoke rpc local users.Users Find data { id: "1" }`, "Find", "RPC Find not found on users.Users, known RPCs are Get"},
+		// the caret is drawn at the first column too
+		{`nope: "1"`, "nope", "Field nope not found on users.GetRequest, available fields are id, limit, values"},
+		{`invoke scalar local users.Users Get data { id: "1", limit: "ten" }`, `"ten"`, "Field limit is of int32 and expects an integer"},
+		{`  response.nam isEmpty()`, "nam", "Field nam not found on users.User, available fields are age, name"},
+	} {
+		line := 0
+		for i, text := range lines {
+			if text == test.line {
+				line = i + 1
+			}
+		}
+		want := fmt.Sprintf("%s\nRelated line on file: %s:%d\n%s\n%s^\n", test.msg, path, line, test.line, strings.Repeat(" ", strings.Index(test.line, test.token)))
+		if !strings.Contains(printed, want) {
+			t.Errorf("output does not contain\n%s\ngot:\n%s", want, printed)
+		}
+	}
+	if problems != 5 {
+		t.Errorf("found %d problem(s), want 5:\n%s", problems, printed)
+	}
+}
+
+func TestCheckReflectionOnly(t *testing.T) {
+	problems, printed, path := checkFiles(t, map[string]string{
+		"a.trpc": testHeader + `
+endpoint local "127.0.0.1" port 50051
+invoke service local users.Nope Get data { nope: 1 }
+`,
+	})
+	if problems != 0 {
+		t.Errorf("found %d problem(s) in a file without protofile or protoset:\n%s", problems, printed)
+	}
+	if want := path + " imports no protofile or protoset"; !strings.Contains(printed, want) {
+		t.Errorf("output does not contain %q:\n%s", want, printed)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/fatih/color"
+)
+
+const (
+	formatIndent = "  "
+	// maxInlineValue is the longest object or list written on one line
+	maxInlineValue = 60
+)
+
+type fmtCmd struct {
+	Files []string `required existing file arg help:"TRPC(Test RPC) file(s) to format."`
+	Check bool     `help:"Only list the files which are not formatted, the exit code is 1 when there are any."`
+	Write bool     `short:"w" help:"Write the formatted files in place rather than printing them."`
+}
+
+func (cmd *fmtCmd) Run() error {
+	unformatted, invalid := 0, 0
+	for _, file := range cmd.Files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return trpcError{ExitCode: 1, Msg: err.Error()}
+		}
+		trpc := &Trpc{}
+		if err := parser.Parse(file, bytes.NewReader(source), trpc); err != nil {
+			// a file which does not parse is left as it is
+			fmt.Println(err)
+			invalid++
+			continue
+		}
+		formatted := formatTrpc(trpc, source)
+		switch {
+		case cmd.Check:
+			if !bytes.Equal(formatted, source) {
+				fmt.Println(file)
+				unformatted++
+			}
+		case cmd.Write:
+			if bytes.Equal(formatted, source) {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				return trpcError{ExitCode: 1, Msg: err.Error()}
+			}
+			if err := os.WriteFile(file, formatted, info.Mode()); err != nil {
+				return trpcError{ExitCode: 1, Msg: err.Error()}
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	if invalid > 0 {
+		return trpcError{ExitCode: 2, Msg: fmt.Sprintf("%d file(s) could not be parsed", invalid)}
+	}
+	if unformatted > 0 {
+		color.Yellow("%d file(s) are not formatted, trpc fmt -w formats them", unformatted)
+		return trpcError{ExitCode: 1}
+	}
+	return nil
+}
+
+type sourceToken struct {
+	text   string
+	line   int
+	offset int
+}
+
+type sourceComment struct {
+	text   string
+	line   int
+	offset int
+	// trailing is a comment following code on its line
+	trailing bool
+}
+
+// formatter writes a parsed file back in its canonical form. The grammar
+// drops comments so they are scanned from the source and written before the
+// first line coming after them, the tokens of the source tell the lines of
+// the brackets closing blocks so comments stay inside their block.
+type formatter struct {
+	lines    []string
+	depth    int
+	tokens   []sourceToken
+	cursor   int
+	comments []sourceComment
+}
+
+// formatTrpc formats the parsed trpc of source:
+//
+//   - two spaces indent blocks and entries of blocks are written one per line
+//     without commas
+//   - objects and lists are written on one line, with commas, when they are
+//     short and have no comments
+//   - strings are quoted by double quotes
+//   - options of endpoints, invokes and retries are written in the order of
+//     the grammar
+//   - consecutive imports, variables or endpoints are kept together, other
+//     entries are separated by an empty line
+func formatTrpc(trpc *Trpc, source []byte) []byte {
+	f := &formatter{}
+	f.scan(source)
+	previous := ""
+	for _, entery := range trpc.Entries {
+		kind := entryKind(entery)
+		if previous != "" && (kind != previous || kind == "block") {
+			f.blank()
+		}
+		previous = kind
+		f.entry(entery)
+	}
+	f.flush(math.MaxInt)
+	return []byte(strings.Join(f.lines, "\n") + "\n")
+}
+
+func entryKind(entery *Entry) string {
+	switch {
+	case entery.TestName != "":
+		return "header"
+	case entery.ImportPath != "" || entery.ImportProto != "" || entery.ImportProtoSet != "" || entery.Include != "":
+		return "import"
+	case entery.Let != nil:
+		return "let"
+	case entery.Endpoint != nil:
+		return "endpoint"
+	}
+	return "block"
+}
+
+func (f *formatter) scan(source []byte) {
+	var s scanner.Scanner
+	s.Init(bytes.NewReader(source))
+	s.Mode = scanner.GoTokens &^ scanner.SkipComments
+	s.Error = func(*scanner.Scanner, string) {}
+	lastLine := 0
+	for token := s.Scan(); token != scanner.EOF; token = s.Scan() {
+		pos := s.Position
+		if token == scanner.Comment {
+			f.comments = append(f.comments, sourceComment{text: s.TokenText(), line: pos.Line, offset: pos.Offset, trailing: pos.Line == lastLine})
+			continue
+		}
+		f.tokens = append(f.tokens, sourceToken{text: s.TokenText(), line: pos.Line, offset: pos.Offset})
+		lastLine = s.Pos().Line
+	}
+}
+
+// write writes a line coming from line src of the source, 0 when it is not
+// known, after the comments before it.
+func (f *formatter) write(src int, text string) {
+	if src > 0 {
+		f.flush(src)
+	}
+	f.lines = append(f.lines, strings.Repeat(formatIndent, f.depth)+text)
+}
+
+// join adds text to the last line.
+func (f *formatter) join(text string) {
+	f.lines[len(f.lines)-1] += text
+}
+
+// emit writes text on a line of its own or joins it to the last line.
+func (f *formatter) emit(join bool, src int, text string) {
+	if join {
+		f.join(" " + text)
+	} else {
+		f.write(src, text)
+	}
+}
+
+func (f *formatter) blank() {
+	if len(f.lines) > 0 && f.lines[len(f.lines)-1] != "" {
+		f.lines = append(f.lines, "")
+	}
+}
+
+// flush writes the comments before line, a trailing comment at the end of
+// the last line written.
+func (f *formatter) flush(line int) {
+	for len(f.comments) > 0 && f.comments[0].line < line {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+		last := len(f.lines) - 1
+		for last >= 0 && f.lines[last] == "" {
+			last--
+		}
+		if comment.trailing && last >= 0 {
+			f.lines[last] += " " + comment.text
+		} else {
+			f.lines = append(f.lines, strings.Repeat(formatIndent, f.depth)+comment.text)
+		}
+	}
+}
+
+// seek moves the cursor to the first token of the node at pos.
+func (f *formatter) seek(pos lexer.Position) {
+	for f.cursor = 0; f.cursor < len(f.tokens) && f.tokens[f.cursor].offset < pos.Offset; f.cursor++ {
+	}
+}
+
+// lineOf is the line of the next token which is keyword.
+func (f *formatter) lineOf(keyword string) int {
+	for i := f.cursor; i < len(f.tokens); i++ {
+		if f.tokens[i].text == keyword {
+			f.cursor = i + 1
+			return f.tokens[i].line
+		}
+	}
+	return 0
+}
+
+// opening finds the bracket opening the next block, the one following
+// keyword when it is given, and returns the index of the token closing it.
+// The cursor moves past the opening bracket.
+func (f *formatter) opening(keyword string) int {
+	i := f.cursor
+	if keyword != "" {
+		for ; i < len(f.tokens); i++ {
+			// the keyword of a block is followed by its bracket, or by the
+			// name of a profile and its bracket
+			if f.tokens[i].text == keyword && (f.isOpen(i+1) || f.isOpen(i+2)) {
+				break
+			}
+		}
+	}
+	for ; i < len(f.tokens) && !f.isOpen(i); i++ {
+	}
+	depth := 0
+	for j := i; j < len(f.tokens); j++ {
+		switch f.tokens[j].text {
+		case "{", "[":
+			depth++
+		case "}", "]":
+			depth--
+			if depth == 0 {
+				f.cursor = i + 1
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func (f *formatter) isOpen(i int) bool {
+	return i < len(f.tokens) && (f.tokens[i].text == "{" || f.tokens[i].text == "[")
+}
+
+// block writes, or joins, text opening a block of which the brackets follow
+// keyword, the body and the closing bracket.
+func (f *formatter) block(join bool, src int, text string, keyword string, body func()) {
+	closing := f.opening(keyword)
+	f.emit(join, src, text)
+	f.depth++
+	body()
+	f.closeBlock(closing, text[len(text)-1:])
+}
+
+func (f *formatter) closeBlock(closing int, open string) {
+	if closing >= 0 {
+		f.flush(f.tokens[closing].line)
+		f.cursor = closing + 1
+	}
+	f.depth--
+	if open == "[" {
+		f.write(0, "]")
+	} else {
+		f.write(0, "}")
+	}
+}
+
+func (f *formatter) entry(entery *Entry) {
+	f.seek(entery.Pos)
+	switch {
+	case entery.TestName != "":
+		f.write(f.lineOf("test"), "test "+requote(entery.TestName))
+		f.write(f.lineOf("desc"), "desc "+requote(entery.Description))
+		f.write(f.lineOf("trpc"), "trpc "+requote(entery.TrpcVersion))
+		if entery.MaxTime != 0 {
+			f.write(f.lineOf("maxtime"), "maxtime "+formatFloat(entery.MaxTime))
+		}
+		if entery.Timeout != 0 {
+			f.write(f.lineOf("timeout"), "timeout "+formatFloat(entery.Timeout))
+		}
+		if entery.VerboseLevel != 0 {
+			f.write(f.lineOf("verbose"), "verbose "+strconv.Itoa(entery.VerboseLevel))
+		}
+	case entery.ImportPath != "":
+		f.write(entery.Pos.Line, "importpath "+requote(entery.ImportPath))
+	case entery.ImportProto != "":
+		f.write(entery.Pos.Line, "import protofile "+requote(entery.ImportProto))
+	case entery.ImportProtoSet != "":
+		f.write(entery.Pos.Line, "import protoset "+requote(entery.ImportProtoSet))
+	case entery.Include != "":
+		f.write(entery.Pos.Line, "include "+requote(entery.Include))
+	case entery.Let != nil:
+		f.let(entery.Let)
+	case entery.Profile != nil:
+		profile := entery.Profile
+		f.block(false, profile.Pos.Line, "profile "+requote(profile.Name)+" {", "profile", func() {
+			for _, profileEntry := range profile.Entries {
+				f.seek(profileEntry.Pos)
+				if profileEntry.Let != nil {
+					f.let(profileEntry.Let)
+				} else {
+					f.endpoint(profileEntry.Endpoint)
+				}
+			}
+		})
+	case entery.Endpoint != nil:
+		f.endpoint(entery.Endpoint)
+	case entery.Invoke != nil:
+		f.invoke(entery.Invoke)
+	case entery.Setup != nil:
+		f.phase(entery.Pos.Line, "setup", entery.Setup)
+	case entery.Teardown != nil:
+		f.phase(entery.Pos.Line, "teardown", entery.Teardown)
+	}
+}
+
+func (f *formatter) let(let *Let) {
+	f.value(false, let.Pos.Line, "let "+let.Name+" = ", &let.Value)
+}
+
+func (f *formatter) phase(src int, keyword string, phase *Phase) {
+	f.block(false, src, keyword+" {", keyword, func() {
+		for i, invoke := range phase.Invokes {
+			if i > 0 {
+				f.blank()
+			}
+			f.invoke(invoke)
+		}
+	})
+}
+
+func (f *formatter) endpoint(endpoint *Endpoint) {
+	text := "endpoint " + endpoint.Name
+	if endpoint.Tls {
+		text += " tls"
+	}
+	text += " " + f.inline(endpoint.Host) + " port " + f.inline(endpoint.PortValue)
+	options := []struct {
+		name  string
+		value *Value
+	}{
+		{"cacert", endpoint.CACertValue},
+		{"cert", endpoint.CertValue},
+		{"key", endpoint.KeyValue},
+	}
+	for _, option := range options {
+		if option.value != nil {
+			text += " " + option.name + " " + f.inline(option.value)
+		}
+	}
+	if endpoint.Insecure {
+		text += " insecureSkipVerify"
+	}
+	if endpoint.AuthorityValue != nil {
+		text += " authority " + f.inline(endpoint.AuthorityValue)
+	}
+	if endpoint.ServerNameValue != nil {
+		text += " serverName " + f.inline(endpoint.ServerNameValue)
+	}
+	if endpoint.PerfixPath != "" {
+		text += " path " + endpoint.PerfixPath
+	}
+	if endpoint.ReflectionPerfixPath != "" {
+		text += " reflectPath " + endpoint.ReflectionPerfixPath
+	}
+	if endpoint.IgnoreTrailers {
+		text += " ignTrailer"
+	}
+	f.write(endpoint.Pos.Line, text)
+}
+
+func (f *formatter) invoke(invoke *Invoke) {
+	f.seek(invoke.Pos)
+	text := fmt.Sprintf("invoke %s %s %s %s", invoke.Name, invoke.EndPoint, invoke.Service, invoke.RPC)
+	if invoke.Goal != "" {
+		text += " goal " + requote(invoke.Goal)
+	}
+	if invoke.Fresh {
+		text += " fresh"
+	}
+	if invoke.Timeout != nil {
+		text += " timeout " + f.inline(invoke.Timeout)
+	}
+	if invoke.Deadline != nil {
+		text += " deadline " + f.inline(invoke.Deadline)
+	}
+	if cases := invoke.Cases; cases != nil {
+		text += " cases"
+		if cases.Var != "" {
+			text += " " + cases.Var + " in"
+		}
+		if cases.File != nil {
+			f.value(false, invoke.Pos.Line, text+" file ", cases.File)
+		} else {
+			f.value(false, invoke.Pos.Line, text+" ", cases.Rows)
+		}
+		if cases.NameBy != "" {
+			f.join(" by " + cases.NameBy)
+		}
+	} else {
+		f.write(invoke.Pos.Line, text)
+	}
+
+	if invoke.Headers != nil {
+		f.block(true, 0, "headers {", "headers", func() {
+			for _, header := range invoke.Headers {
+				f.value(false, header.Pos.Line, requote(header.Key)+": ", &header.Value)
+			}
+		})
+	}
+	if invoke.Data != nil {
+		f.block(true, 0, "data {", "data", func() {
+			for _, data := range invoke.Data {
+				f.value(false, data.Pos.Line, data.Key+": ", &data.Value)
+			}
+		})
+	}
+	if invoke.Stream != nil {
+		f.lineOf("stream")
+		f.value(true, 0, "stream ", &Value{Pos: invoke.Stream.Pos, Array: invoke.Stream})
+	}
+	if invoke.Exchange != nil {
+		f.block(true, 0, "exchange {", "exchange", func() {
+			for _, step := range invoke.Exchange {
+				f.seek(step.Pos)
+				f.lineOf("send")
+				f.value(false, step.Pos.Line, "send ", &Value{Pos: step.Send.Pos, Map: step.Send})
+				if step.Expects != nil {
+					f.expects(step.Expects)
+				}
+			}
+		})
+	}
+	if retry := invoke.Retry; retry != nil {
+		f.block(true, 0, "retry {", "retry", func() {
+			// the options of a retry may come in any order
+			lineOf := func(keyword string) int {
+				f.seek(retry.Pos)
+				return f.lineOf(keyword)
+			}
+			if retry.Attempts != nil {
+				f.write(lineOf("attempts"), fmt.Sprintf("attempts %d", *retry.Attempts))
+			}
+			if retry.Interval != nil {
+				f.write(retry.Interval.Pos.Line, "interval "+f.inline(retry.Interval))
+			}
+			if retry.Backoff != "" {
+				f.write(lineOf("backoff"), "backoff "+retry.Backoff)
+			}
+			if retry.Until != nil {
+				f.block(false, lineOf("until"), "until {", "", func() {
+					f.expectLines(retry.Until)
+				})
+			}
+		})
+	}
+	if invoke.SourceExpects != nil {
+		f.expects(invoke.SourceExpects)
+	}
+}
+
+// expects joins an expects block to the last line.
+func (f *formatter) expects(expects []*Expect) {
+	f.block(true, 0, "expects {", "expects", func() {
+		f.expectLines(expects)
+	})
+}
+
+func (f *formatter) expectLines(expects []*Expect) {
+	for _, expect := range expects {
+		text := ""
+		if expect.Quantifier != nil {
+			text = *expect.Quantifier + " "
+		}
+		text += expect.Path.String() + " " + expect.Function.Name + "("
+		for i, arg := range expect.Function.Args {
+			if i > 0 {
+				text += ", "
+			}
+			text += f.inline(arg)
+		}
+		text += ")"
+		if expect.OnFail != nil {
+			text += " onFail " + *expect.OnFail
+		}
+		f.write(expect.Pos.Line, text)
+	}
+}
+
+// value writes prefix and the value, on one line when it is a scalar or a
+// short object or list without comments, otherwise one entry per line.
+// join adds the first line to the last line written.
+func (f *formatter) value(join bool, src int, prefix string, value *Value) {
+	if value.Map == nil && value.Array == nil {
+		f.emit(join, src, prefix+f.inline(value))
+		return
+	}
+	f.seek(value.Pos)
+	closing := f.opening("")
+	if text := f.inline(value); len(text) <= maxInlineValue && !f.commented(value.Pos, closing) {
+		f.emit(join, src, prefix+text)
+		if closing >= 0 {
+			f.cursor = closing + 1
+		}
+		return
+	}
+	if value.Map != nil {
+		f.emit(join, src, prefix+"{")
+		f.depth++
+		for _, entry := range value.Map.Entries {
+			f.value(false, entry.Pos.Line, entry.Key+": ", &entry.Value)
+		}
+		f.closeBlock(closing, "{")
+	} else {
+		f.emit(join, src, prefix+"[")
+		f.depth++
+		for _, element := range value.Array.Elements {
+			f.value(false, element.Pos.Line, "", element)
+		}
+		f.closeBlock(closing, "[")
+	}
+}
+
+// commented tells a comment is between the value at pos and its closing bracket.
+func (f *formatter) commented(pos lexer.Position, closing int) bool {
+	if closing < 0 {
+		return false
+	}
+	for _, comment := range f.comments {
+		if comment.offset > pos.Offset && comment.offset < f.tokens[closing].offset {
+			return true
+		}
+	}
+	return false
+}
+
+// inline writes the value on one line.
+func (f *formatter) inline(value *Value) string {
+	switch {
+	case value.String != nil:
+		return requote(*value.String)
+	case value.Env != nil:
+		text := "env(" + requote(value.Env.Name)
+		if value.Env.Default != nil {
+			text += ", " + f.inline(value.Env.Default)
+		}
+		return text + ")"
+	case value.Bool != nil:
+		return strconv.FormatBool(bool(*value.Bool))
+	case value.Now:
+		return "now()"
+	case value.Reference != nil:
+		return value.Reference.String()
+	case value.RawString != nil:
+		return *value.RawString
+	case value.Float != nil:
+		return formatFloat(*value.Float)
+	case value.Int != nil:
+		return strconv.FormatInt(*value.Int, 10)
+	case value.Map != nil:
+		entries := make([]string, len(value.Map.Entries))
+		for i, entry := range value.Map.Entries {
+			entries[i] = entry.Key + ": " + f.inline(&entry.Value)
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case value.Array != nil:
+		elements := make([]string, len(value.Array.Elements))
+		for i, element := range value.Array.Elements {
+			elements[i] = f.inline(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	}
+	return ""
+}
+
+// requote writes a string token by double quotes, whichever way it was quoted.
+func requote(token string) string {
+	str, err := strconv.Unquote(token)
+	if err != nil {
+		return token
+	}
+	return strconv.Quote(str)
+}
+
+// formatFloat writes the float so it is read back as a float, 7.0 rather than 7.
+func formatFloat(f float64) string {
+	text := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".eEn") {
+		text += ".0"
+	}
+	return text
+}
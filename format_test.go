@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func formatSource(t *testing.T, name string, source []byte) []byte {
+	t.Helper()
+	trpc := &Trpc{}
+	if err := parser.Parse(name, bytes.NewReader(source), trpc); err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return formatTrpc(trpc, source)
+}
+
+func TestFormat(t *testing.T) {
+	for _, name := range []string{
+		// leading, trailing and block comments
+		"comments",
+		// optional commas of data, objects, lists and headers
+		"commas",
+		// strings, header keys included, are written by double quotes
+		"quoting",
+		// options of the header and of invokes, blocks and entry groups
+		"ordering",
+	} {
+		path := filepath.Join("testdata", "format", name+".trpc")
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted := formatSource(t, path, source)
+		golden(t, filepath.Join("format", name+".golden"), formatted)
+		if again := formatSource(t, path, formatted); !bytes.Equal(again, formatted) {
+			t.Errorf("%s: formatting the formatted file changes it, got:\n%s\nwant:\n%s", name, again, formatted)
+		}
+	}
+}
+
+func TestFmtCheck(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"formatted.trpc":   "test \"test\"\ndesc \"a test\"\ntrpc \"v0.0.1\"\n",
+		"unformatted.trpc": testHeader + "\n",
+	})
+	formatted := filepath.Join(dir, "formatted.trpc")
+	unformatted := filepath.Join(dir, "unformatted.trpc")
+	for _, test := range []struct {
+		files []string
+		code  int
+	}{
+		{[]string{formatted}, 0},
+		{[]string{formatted, unformatted}, 1},
+	} {
+		var err error
+		printed := captureOutput(t, func() { err = (&fmtCmd{Files: test.files, Check: true}).Run() })
+		if code := exitCodeOf(err); code != test.code {
+			t.Errorf("fmt --check %v exits with %d, want %d", test.files, code, test.code)
+		}
+		if listed := strings.Contains(printed, unformatted+"\n"); listed != (test.code != 0) {
+			t.Errorf("fmt --check %v lists the unformatted file: %v, output:\n%s", test.files, listed, printed)
+		}
+		if strings.Contains(printed, formatted+"\n") {
+			t.Errorf("fmt --check %v lists the formatted file:\n%s", test.files, printed)
+		}
+	}
+}
+
+func TestFmtWrite(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "format", "commas.trpc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeFiles(t, map[string]string{"a.trpc": string(source)})
+	path := filepath.Join(dir, "a.trpc")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var runErr error
+	printed := captureOutput(t, func() { runErr = (&fmtCmd{Files: []string{path}, Write: true}).Run() })
+	if runErr != nil || printed != "" {
+		t.Fatalf("fmt -w: error %v, output %q", runErr, printed)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, filepath.Join("format", "commas.golden"), written)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("fmt -w changes the mode of the file to %v, want -rw-------", info.Mode())
+	}
+}
@@ -30,11 +30,11 @@ var strCodeFromFnName = map[string]codes.Code{
 
 func CodeFunction(fn string) (codeFunction, error) {
 	if code, ok := strCodeFromFnName[fn]; ok {
-		fn := func(expected codes.Code) error {
-			if code == expected {
+		fn := func(actual codes.Code) error {
+			if code == actual {
 				return nil
 			}
-			return fmt.Errorf("Response code expected to be (%v) but got (%v)", expected, code)
+			return fmt.Errorf("Response code expected to be (%v) but got (%v)", code, actual)
 		}
 		return fn, nil
 	}
@@ -1,18 +1,419 @@
 package functions
 
-//dataFunction (value, expectedValue, checkType)
-type dataFunction = func(any, any, bool) error
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
-var strDataFuncToFunc = map[string]dataFunction{
-	"isEmpty": isEmpty,
+// dataFunction examines a value of the response
+type dataFunction = func(any) error
+
+// dataFunctionBuilder validates the arguments of a function and returns the
+// function examining the value with them.
+type dataFunctionBuilder = func(args []any) (dataFunction, error)
+
+var strDataFuncToFunc = map[string]dataFunctionBuilder{
+	"hasValue":       noArgs(hasValue),
+	"isNull":         noArgs(isNull),
+	"isEmpty":        noArgs(isEmpty),
+	"isNotEmpty":     noArgs(isNotEmpty),
+	"isTrue":         noArgs(isBool(true)),
+	"isFalse":        noArgs(isBool(false)),
+	"isEqual":        isEqual,
+	"isNotEqual":     isNotEqual,
+	"deepEquals":     deepEquals,
+	"matchesPartial": matchesPartial,
+	"isGreaterThan":  compareTo("greater than", func(val, arg float64) bool { return val > arg }),
+	"isLessThan":     compareTo("less than", func(val, arg float64) bool { return val < arg }),
+	"isBetween":      isBetween,
+	"matches":        matches,
+	"contains":       contains,
+	"startsWith":     startsWith,
+	"hasLength":      hasLength,
+	"hasCount":       hasCount,
+	"isOneOf":        isOneOf,
+	"isType":         isType,
+	"isBefore":       compareTime("before", func(val, arg time.Time) bool { return val.Before(arg) }),
+	"isAfter":        compareTime("after", func(val, arg time.Time) bool { return val.After(arg) }),
+	"isWithin":       isWithin,
+	"isAnyOf":        isAnyOf,
+}
+
+// DataFunction returns the function fn with its arguments, the error tells
+// the function is unknown or the arguments are invalid.
+func DataFunction(fn string, args []any) (dataFunction, error) {
+	if builder, ok := strDataFuncToFunc[fn]; ok {
+		dataFn, err := builder(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		return dataFn, nil
+	}
+	return nil, fmt.Errorf("Invalid function for examining data: \"%s\"", fn)
+}
+
+func checkArgs(args []any, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("expects %d argument(s) but got %d", count, len(args))
+	}
+	return nil
+}
+
+func noArgs(fn dataFunction) dataFunctionBuilder {
+	return func(args []any) (dataFunction, error) {
+		if err := checkArgs(args, 0); err != nil {
+			return nil, err
+		}
+		return fn, nil
+	}
+}
+
+// ToNumber converts any number, or a string holding one, to float64.
+func ToNumber(val any) (float64, bool) {
+	switch num := val.(type) {
+	case int:
+		return float64(num), true
+	case int32:
+		return float64(num), true
+	case int64:
+		return float64(num), true
+	case uint32:
+		return float64(num), true
+	case uint64:
+		return float64(num), true
+	case float32:
+		return float64(num), true
+	case float64:
+		return num, true
+	case string:
+		f, err := strconv.ParseFloat(num, 64)
+		return f, err == nil && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+// length is the number of characters of a string and the number of items of
+// bytes, lists and maps.
+func length(val any) (int, bool) {
+	if str, ok := val.(string); ok {
+		return utf8.RuneCountInString(str), true
+	}
+	if val == nil {
+		return 0, false
+	}
+	switch rv := reflect.ValueOf(val); rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), true
+	}
+	return 0, false
 }
 
-//func typeOf(data any) string {
-//	switch dt := data.(type) {
-//		case
-//	}
-//}
+// TypeName is the type of a value as it is written in a .trpc file.
+func TypeName(val any) string {
+	if val == nil {
+		return "null"
+	}
+	if _, ok := val.(string); ok {
+		return "string"
+	}
+	if _, ok := val.(bool); ok {
+		return "bool"
+	}
+	if _, ok := val.([]byte); ok {
+		return "bytes"
+	}
+	if _, ok := ToNumber(val); ok {
+		return "number"
+	}
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.Slice, reflect.Array:
+		return "list"
+	}
+	return "object"
+}
+
+func hasValue(val any) error {
+	if val == nil {
+		return fmt.Errorf("expected to have value but got null")
+	}
+	return nil
+}
 
-func isEmpty(val any, _ any, checkType bool) error {
+func isNull(val any) error {
+	if val != nil {
+		return fmt.Errorf("expected to be null but got (%v) %T", val, val)
+	}
 	return nil
 }
+
+func isEmpty(val any) error {
+	if val == nil {
+		return nil
+	}
+	if l, ok := length(val); ok && l == 0 {
+		return nil
+	}
+	return fmt.Errorf("expected to be empty but got \"%v\"", val)
+}
+
+func isNotEmpty(val any) error {
+	if val == nil {
+		return fmt.Errorf("expected to be not empty but got null")
+	}
+	if l, ok := length(val); ok && l == 0 {
+		return fmt.Errorf("expected to be not empty but got empty %s", TypeName(val))
+	}
+	return nil
+}
+
+func isBool(expected bool) dataFunction {
+	return func(val any) error {
+		if b, ok := val.(bool); ok && b == expected {
+			return nil
+		}
+		return fmt.Errorf("expected to be %v but got \"%v\"", expected, val)
+	}
+}
+
+func isEqual(args []any) (dataFunction, error) {
+	if err := checkArgs(args, 1); err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		if !Equal(val, args[0]) {
+			return fmt.Errorf("expected to be \"%v\" but got \"%v\"", args[0], val)
+		}
+		return nil
+	}, nil
+}
+
+func isNotEqual(args []any) (dataFunction, error) {
+	if err := checkArgs(args, 1); err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		if Equal(val, args[0]) {
+			return fmt.Errorf("expected not to be \"%v\"", args[0])
+		}
+		return nil
+	}, nil
+}
+
+func numberArgs(args []any, count int) ([]float64, error) {
+	if err := checkArgs(args, count); err != nil {
+		return nil, err
+	}
+	nums := make([]float64, count)
+	for i, arg := range args {
+		num, ok := ToNumber(arg)
+		if !ok {
+			return nil, fmt.Errorf("argument %d must be a number but got \"%v\"", i+1, arg)
+		}
+		nums[i] = num
+	}
+	return nums, nil
+}
+
+// compareTo compares numbers, or durations when the argument is a duration
+// such as "200ms".
+func compareTo(relation string, compare func(val, arg float64) bool) dataFunctionBuilder {
+	return func(args []any) (dataFunction, error) {
+		if durations, ok := durationArgs(args, 1); ok {
+			return compareDuration(relation, compare, durations[0]), nil
+		}
+		nums, err := numberArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(val any) error {
+			num, ok := ToNumber(val)
+			if !ok {
+				return fmt.Errorf("expected to be a number %s %v but got \"%v\"", relation, args[0], val)
+			}
+			if !compare(num, nums[0]) {
+				return fmt.Errorf("expected to be %s %v but got %v", relation, args[0], val)
+			}
+			return nil
+		}, nil
+	}
+}
+
+func isBetween(args []any) (dataFunction, error) {
+	if durations, ok := durationArgs(args, 2); ok {
+		return func(val any) error {
+			d, ok := ToDuration(val)
+			if !ok || d < durations[0] || d > durations[1] {
+				return fmt.Errorf("expected to be a duration between %v and %v but got \"%v\"", durations[0], durations[1], val)
+			}
+			return nil
+		}, nil
+	}
+	nums, err := numberArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		num, ok := ToNumber(val)
+		if !ok || num < nums[0] || num > nums[1] {
+			return fmt.Errorf("expected to be between %v and %v but got \"%v\"", args[0], args[1], val)
+		}
+		return nil
+	}, nil
+}
+
+func stringArg(args []any) (string, error) {
+	if err := checkArgs(args, 1); err != nil {
+		return "", err
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("argument must be a string but got \"%v\"", args[0])
+	}
+	return str, nil
+}
+
+func matches(args []any) (dataFunction, error) {
+	pattern, err := stringArg(args)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+	return func(val any) error {
+		str, ok := val.(string)
+		if !ok || !re.MatchString(str) {
+			return fmt.Errorf("expected to match /%s/ but got \"%v\"", pattern, val)
+		}
+		return nil
+	}, nil
+}
+
+func startsWith(args []any) (dataFunction, error) {
+	prefix, err := stringArg(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		str, ok := val.(string)
+		if !ok || !strings.HasPrefix(str, prefix) {
+			return fmt.Errorf("expected to start with \"%s\" but got \"%v\"", prefix, val)
+		}
+		return nil
+	}, nil
+}
+
+// contains looks for a substring in strings, an item in lists and a key in maps.
+func contains(args []any) (dataFunction, error) {
+	if err := checkArgs(args, 1); err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		switch container := val.(type) {
+		case string:
+			if sub, ok := args[0].(string); ok && strings.Contains(container, sub) {
+				return nil
+			}
+		case []any:
+			for _, item := range container {
+				if Equal(item, args[0]) {
+					return nil
+				}
+			}
+		case map[string]any:
+			if key, ok := args[0].(string); ok {
+				if _, exists := container[key]; exists {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("expected to contain \"%v\" but got \"%v\"", args[0], val)
+	}, nil
+}
+
+func hasLength(args []any) (dataFunction, error) {
+	nums, err := numberArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		l, ok := length(val)
+		if !ok {
+			return fmt.Errorf("expected to have length %v but got %s \"%v\"", args[0], TypeName(val), val)
+		}
+		if float64(l) != nums[0] {
+			return fmt.Errorf("expected to have length %v but got %d", args[0], l)
+		}
+		return nil
+	}, nil
+}
+
+// hasCount counts the items of a list or map, such as the messages of responses.
+func hasCount(args []any) (dataFunction, error) {
+	nums, err := numberArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		var count int
+		switch container := val.(type) {
+		case []any:
+			count = len(container)
+		case map[string]any:
+			count = len(container)
+		default:
+			return fmt.Errorf("expected to have %v item(s) but got %s \"%v\"", args[0], TypeName(val), val)
+		}
+		if float64(count) != nums[0] {
+			return fmt.Errorf("expected to have %v item(s) but got %d", args[0], count)
+		}
+		return nil
+	}, nil
+}
+
+func isOneOf(args []any) (dataFunction, error) {
+	if err := checkArgs(args, 1); err != nil {
+		return nil, err
+	}
+	options, ok := args[0].([]any)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a list but got \"%v\"", args[0])
+	}
+	return func(val any) error {
+		for _, option := range options {
+			if Equal(val, option) {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected to be one of %v but got \"%v\"", options, val)
+	}, nil
+}
+
+var typeNames = []string{"null", "string", "number", "bool", "bytes", "list", "object"}
+
+func isType(args []any) (dataFunction, error) {
+	expected, err := stringArg(args)
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, name := range typeNames {
+		known = known || name == expected
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown type \"%s\", use one of %s", expected, strings.Join(typeNames, ", "))
+	}
+	return func(val any) error {
+		if actual := TypeName(val); actual != expected {
+			return fmt.Errorf("expected to be of type %s but got %s \"%v\"", expected, actual, val)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,138 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDataFunctionArgs(t *testing.T) {
+	for _, test := range []struct {
+		fn   string
+		args []any
+		err  string
+	}{
+		{"nope", nil, `Invalid function for examining data: "nope"`},
+		{"isNull", []any{int64(1)}, "isNull: expects 0 argument(s) but got 1"},
+		{"isTrue", []any{true}, "isTrue: expects 0 argument(s) but got 1"},
+		{"isEqual", nil, "isEqual: expects 1 argument(s) but got 0"},
+		{"isNotEqual", []any{int64(1), int64(2)}, "isNotEqual: expects 1 argument(s) but got 2"},
+		{"deepEquals", nil, "deepEquals: expects 1 argument(s) but got 0"},
+		{"isGreaterThan", []any{"x"}, `isGreaterThan: argument 1 must be a number but got "x"`},
+		{"isLessThan", nil, "isLessThan: expects 1 argument(s) but got 0"},
+		{"isBetween", []any{int64(1)}, "isBetween: expects 2 argument(s) but got 1"},
+		{"isBetween", []any{int64(1), true}, `isBetween: argument 2 must be a number but got "true"`},
+		{"matches", []any{int64(1)}, `matches: argument must be a string but got "1"`},
+		{"matches", []any{"("}, "matches: invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"startsWith", nil, "startsWith: expects 1 argument(s) but got 0"},
+		{"contains", nil, "contains: expects 1 argument(s) but got 0"},
+		{"hasLength", []any{"x"}, `hasLength: argument 1 must be a number but got "x"`},
+		{"hasCount", nil, "hasCount: expects 1 argument(s) but got 0"},
+		{"isOneOf", []any{"a"}, `isOneOf: argument must be a list but got "a"`},
+		{"isType", []any{"int"}, `isType: unknown type "int", use one of null, string, number, bool, bytes, list, object`},
+		{"isBefore", []any{"yesterday"}, `isBefore: argument 1 must be a time such as now() or "2006-01-02T15:04:05Z" but got "yesterday"`},
+		{"isWithin", nil, "isWithin: expects 1 or 2 argument(s) but got 0"},
+		{"isWithin", []any{"soon"}, `isWithin: argument 1 must be a duration such as "5s" but got "soon"`},
+		{"isAnyOf", []any{int64(1)}, `isAnyOf: argument must be a string but got "1"`},
+	} {
+		_, err := DataFunction(test.fn, test.args)
+		if err == nil {
+			t.Errorf("%s%v: expected error %q", test.fn, test.args, test.err)
+		} else if err.Error() != test.err {
+			t.Errorf("%s%v: error %q, want %q", test.fn, test.args, err, test.err)
+		}
+	}
+}
+
+func TestDataFunctions(t *testing.T) {
+	for _, test := range []struct {
+		fn   string
+		args []any
+		val  any
+		pass bool
+	}{
+		{"hasValue", nil, int64(0), true},
+		{"hasValue", nil, nil, false},
+		{"isNull", nil, nil, true},
+		{"isNull", nil, "", false},
+		{"isEmpty", nil, "", true},
+		{"isEmpty", nil, []any{}, true},
+		{"isEmpty", nil, nil, true},
+		{"isEmpty", nil, []any{int64(1)}, false},
+		{"isNotEmpty", nil, map[string]any{"a": nil}, true},
+		{"isNotEmpty", nil, []byte{}, false},
+		{"isTrue", nil, true, true},
+		{"isTrue", nil, "true", false},
+		{"isFalse", nil, false, true},
+		{"isEqual", []any{int64(3)}, int32(3), true},
+		{"isEqual", []any{int64(3)}, "3", true},
+		{"isEqual", []any{"RED"}, "RED", true},
+		{"isEqual", []any{"3"}, "3.0", false},
+		{"isNotEqual", []any{int64(3)}, 3.5, true},
+		{"isGreaterThan", []any{int64(3)}, uint64(4), true},
+		{"isGreaterThan", []any{int64(3)}, "4", true},
+		{"isGreaterThan", []any{int64(3)}, int64(3), false},
+		{"isGreaterThan", []any{int64(3)}, "many", false},
+		{"isLessThan", []any{1.5}, float32(1.25), true},
+		{"isBetween", []any{int64(1), int64(3)}, int64(3), true},
+		{"isBetween", []any{int64(1), int64(3)}, int64(4), false},
+		{"matches", []any{"^a+$"}, "aaa", true},
+		{"matches", []any{"^a+$"}, "ab", false},
+		{"matches", []any{"1"}, int64(1), false},
+		{"contains", []any{"ell"}, "hello", true},
+		{"contains", []any{int64(2)}, []any{int32(1), int32(2)}, true},
+		{"contains", []any{"k"}, map[string]any{"k": nil}, true},
+		{"contains", []any{"x"}, []any{"y"}, false},
+		{"startsWith", []any{"he"}, "hello", true},
+		{"startsWith", []any{"lo"}, "hello", false},
+		{"hasLength", []any{int64(2)}, "hé", true},
+		{"hasLength", []any{int64(3)}, []byte{1, 2, 3}, true},
+		{"hasLength", []any{int64(1)}, int64(1), false},
+		{"hasCount", []any{int64(2)}, []any{map[string]any{}, map[string]any{}}, true},
+		{"hasCount", []any{int64(1)}, map[string]any{"a": int64(1)}, true},
+		{"hasCount", []any{int64(1)}, "a", false},
+		{"isOneOf", []any{[]any{"A", "B"}}, "B", true},
+		{"isOneOf", []any{[]any{int64(1), int64(2)}}, uint32(2), true},
+		{"isOneOf", []any{[]any{"A", "B"}}, "C", false},
+		{"isType", []any{"number"}, uint32(7), true},
+		{"isType", []any{"string"}, int64(1), false},
+		{"isType", []any{"bytes"}, []byte{}, true},
+		{"isType", []any{"list"}, []any{}, true},
+		{"isType", []any{"object"}, map[string]any{}, true},
+		{"isType", []any{"null"}, nil, true},
+		{"isAnyOf", []any{"pkg.T"}, map[string]any{"@type": "type.googleapis.com/pkg.T"}, true},
+		{"isAnyOf", []any{"pkg.T"}, map[string]any{"@type": "type.googleapis.com/pkg.U"}, false},
+	} {
+		fn, err := DataFunction(test.fn, test.args)
+		if err != nil {
+			t.Errorf("%s%v: unexpected error %v", test.fn, test.args, err)
+			continue
+		}
+		if err := fn(test.val); (err == nil) != test.pass {
+			t.Errorf("%s%v on (%T) %v: error %v, want pass %v", test.fn, test.args, test.val, test.val, err, test.pass)
+		}
+	}
+}
+
+func TestDataFunctionMessages(t *testing.T) {
+	for _, test := range []struct {
+		fn   string
+		args []any
+		val  any
+		err  string
+	}{
+		{"isEqual", []any{int64(3)}, int64(4), `expected to be "3" but got "4"`},
+		{"isGreaterThan", []any{int64(3)}, int64(1), "expected to be greater than 3 but got 1"},
+		{"isNotEmpty", nil, []any{}, "expected to be not empty but got empty list"},
+		{"hasLength", []any{int64(2)}, "abc", "expected to have length 2 but got 3"},
+		{"hasCount", []any{int64(2)}, []any{}, "expected to have 2 item(s) but got 0"},
+		{"isType", []any{"string"}, int64(1), `expected to be of type string but got number "1"`},
+	} {
+		fn, err := DataFunction(test.fn, test.args)
+		if err != nil {
+			t.Fatalf("%s%v: unexpected error %v", test.fn, test.args, err)
+		}
+		if err := fn(test.val); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s%v on %v: error %v, want %q", test.fn, test.args, test.val, err, test.err)
+		}
+	}
+}
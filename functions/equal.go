@@ -0,0 +1,271 @@
+package functions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Normalize turns a value of a response or of a .trpc file into plain maps,
+// lists, strings, booleans and numbers, messages are taken in their JSON form
+// and bytes as base64 as they are shown in the actual response. Integers are
+// kept as int64, or uint64 above it, so large ids are compared exactly, and
+// other numbers become float64.
+func Normalize(val any) any {
+	switch v := val.(type) {
+	case nil, string, bool, float64, int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, item := range v {
+			normalized[key] = Normalize(item)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, item := range v {
+			normalized[i] = Normalize(item)
+		}
+		return normalized
+	case json.Marshaler:
+		jb, err := v.MarshalJSON()
+		if err != nil {
+			return v
+		}
+		var plain any
+		if err := json.Unmarshal(jb, &plain); err != nil {
+			return v
+		}
+		return Normalize(plain)
+	}
+	if num, ok := ToNumber(val); ok {
+		return num
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		normalized := make([]any, rv.Len())
+		for i := range normalized {
+			normalized[i] = Normalize(rv.Index(i).Interface())
+		}
+		return normalized
+	}
+	return val
+}
+
+// Difference is a field of the actual value which does not match the expected one.
+type Difference struct {
+	Path     string
+	Expected any
+	Actual   any
+	// Missing and Unexpected tell the field is only in the expected or only
+	// in the actual value
+	Missing    bool
+	Unexpected bool
+}
+
+// Diff compares normalized values, with partial the fields of actual maps
+// which are not expected are ignored. Null fields of a map are the same as
+// missing ones.
+func Diff(actual any, expected any, partial bool) []Difference {
+	return diff("", actual, expected, partial)
+}
+
+func fieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func diff(path string, actual any, expected any, partial bool) []Difference {
+	switch exp := expected.(type) {
+	case map[string]any:
+		act, ok := actual.(map[string]any)
+		if !ok {
+			break
+		}
+		diffs := make([]Difference, 0)
+		for _, key := range sortedKeys(exp) {
+			actItem, exists := act[key]
+			if (!exists || actItem == nil) && exp[key] != nil {
+				diffs = append(diffs, Difference{Path: fieldPath(path, key), Expected: exp[key], Missing: true})
+				continue
+			}
+			diffs = append(diffs, diff(fieldPath(path, key), actItem, exp[key], partial)...)
+		}
+		if !partial {
+			for _, key := range sortedKeys(act) {
+				if _, exists := exp[key]; !exists && act[key] != nil {
+					diffs = append(diffs, Difference{Path: fieldPath(path, key), Actual: act[key], Unexpected: true})
+				}
+			}
+		}
+		return diffs
+	case []any:
+		act, ok := actual.([]any)
+		if !ok || len(act) != len(exp) {
+			break
+		}
+		diffs := make([]Difference, 0)
+		for i := range exp {
+			diffs = append(diffs, diff(fmt.Sprintf("%s[%d]", path, i), act[i], exp[i], partial)...)
+		}
+		return diffs
+	}
+	if scalarEqual(actual, expected) {
+		return nil
+	}
+	return []Difference{{Path: path, Expected: expected, Actual: actual}}
+}
+
+// scalarEqual compares numbers by value, a number in a string such as an
+// int64 of a JSON message is equal to the number.
+func scalarEqual(actual any, expected any) bool {
+	actStr, actIsStr := actual.(string)
+	expStr, expIsStr := expected.(string)
+	if actIsStr && expIsStr {
+		return actStr == expStr
+	}
+	if equal, ok := numberEqual(actual, expected); ok {
+		return equal
+	}
+	return reflect.DeepEqual(actual, expected)
+}
+
+// numberEqual compares two numbers, or a number and a string holding one.
+// When both are integers they are compared exactly, as float64 would take
+// int64 ids above 2^53 for their neighbours, otherwise as float64.
+func numberEqual(actual any, expected any) (equal bool, ok bool) {
+	actNum, actOk := ToNumber(actual)
+	expNum, expOk := ToNumber(expected)
+	if !actOk || !expOk {
+		return false, false
+	}
+	actInt, actIsInt := exactInteger(actual)
+	expInt, expIsInt := exactInteger(expected)
+	if actIsInt && expIsInt {
+		return actInt.Cmp(expInt) == 0, true
+	}
+	return actNum == expNum, true
+}
+
+// exactInteger is the exact value of an integer, of a float64 without a
+// fraction or of a string holding one of them.
+func exactInteger(val any) (*big.Int, bool) {
+	switch num := val.(type) {
+	case int:
+		return big.NewInt(int64(num)), true
+	case int32:
+		return big.NewInt(int64(num)), true
+	case int64:
+		return big.NewInt(num), true
+	case uint32:
+		return big.NewInt(int64(num)), true
+	case uint64:
+		return new(big.Int).SetUint64(num), true
+	case float32:
+		return exactInteger(float64(num))
+	case float64:
+		if math.IsInf(num, 0) || num != math.Trunc(num) {
+			return nil, false
+		}
+		integer, _ := big.NewFloat(num).Int(nil)
+		return integer, true
+	case string:
+		if integer, ok := new(big.Int).SetString(num, 10); ok {
+			return integer, true
+		}
+		if f, isNum := ToNumber(num); isNum {
+			return exactInteger(f)
+		}
+	}
+	return nil, false
+}
+
+// Equal compares the values after normalizing them.
+func Equal(val any, expected any) bool {
+	return len(Diff(Normalize(val), Normalize(expected), false)) == 0
+}
+
+func jsonString(val any) string {
+	jb, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(jb)
+}
+
+// FormatDiff lists the differences, what is expected in green and what is
+// actual in red.
+func FormatDiff(diffs []Difference) string {
+	lines := make([]string, len(diffs))
+	for i, d := range diffs {
+		path := d.Path
+		if path == "" {
+			path = "(value)"
+		}
+		switch {
+		case d.Missing:
+			lines[i] = fmt.Sprintf("  - %s: missing, expected %s", path, color.GreenString(jsonString(d.Expected)))
+		case d.Unexpected:
+			lines[i] = fmt.Sprintf("  + %s: unexpected %s", path, color.RedString(jsonString(d.Actual)))
+		default:
+			lines[i] = fmt.Sprintf("  ~ %s: expected %s but got %s", path, color.GreenString(jsonString(d.Expected)), color.RedString(jsonString(d.Actual)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func structuralMatch(name string, partial bool) dataFunctionBuilder {
+	return func(args []any) (dataFunction, error) {
+		if err := checkArgs(args, 1); err != nil {
+			return nil, err
+		}
+		expected := Normalize(args[0])
+		return func(val any) error {
+			diffs := Diff(Normalize(val), expected, partial)
+			if len(diffs) == 0 {
+				return nil
+			}
+			return fmt.Errorf("expected to %s but %s field(s) differ:\n%s", name, strconv.Itoa(len(diffs)), FormatDiff(diffs))
+		}, nil
+	}
+}
+
+var (
+	deepEquals     = structuralMatch("deep equal", false)
+	matchesPartial = structuralMatch("match partially", true)
+)
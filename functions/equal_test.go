@@ -0,0 +1,137 @@
+package functions
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, test := range []struct {
+		val  any
+		want any
+	}{
+		{nil, nil},
+		{int32(-1), int64(-1)},
+		{int64(1) << 40, int64(1) << 40},
+		{uint64(7), int64(7)},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{float32(0.5), 0.5},
+		{"7", "7"},
+		{true, true},
+		{[]byte{1, 2, 3}, "AQID"},
+		{time.Date(2020, 1, 1, 0, 0, 0, 5, time.UTC), "2020-01-01T00:00:00.000000005Z"},
+		{[]int32{1, 2}, []any{int64(1), int64(2)}},
+		{[]any{int64(1), "a"}, []any{int64(1), "a"}},
+		{map[string]any{"n": uint32(1), "l": []any{int64(2)}}, map[string]any{"n": int64(1), "l": []any{int64(2)}}},
+	} {
+		if got := Normalize(test.val); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Normalize((%T) %v) = %#v, want %#v", test.val, test.val, got, test.want)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, test := range []struct {
+		val      any
+		expected any
+		equal    bool
+	}{
+		{int32(3), int64(3), true},
+		{uint64(3), 3.0, true},
+		{"3", int64(3), true},
+		{int64(3), "3", true},
+		{"3", "3.0", false},
+		{"a", "b", false},
+		{nil, nil, true},
+		{nil, int64(0), false},
+		{[]any{int32(1), int32(2)}, []any{int64(1), int64(2)}, true},
+		{[]any{int32(1)}, []any{int64(1), int64(2)}, false},
+		{map[string]any{"a": int64(1), "b": nil}, map[string]any{"a": 1.0}, true},
+		{map[string]any{"a": int64(1)}, map[string]any{"a": 1.0, "b": nil}, true},
+		{map[string]any{"a": int64(1), "b": int64(2)}, map[string]any{"a": 1.0}, false},
+		{[]byte{1, 2, 3}, "AQID", true},
+		// integers above 2^53 are compared exactly
+		{int64(9007199254740993), int64(9007199254740992), false},
+		{int64(9007199254740993), int64(9007199254740993), true},
+		{uint64(18446744073709551615), uint64(18446744073709551614), false},
+		{uint64(18446744073709551615), "18446744073709551615", true},
+		{"9007199254740993", int64(9007199254740992), false},
+		{int64(9007199254740993), 9007199254740992.0, false},
+		{int64(1) << 60, float64(int64(1) << 60), true},
+		{int64(3), "3.0", true},
+		{int64(3), 3.5, false},
+		{0.1, "0.1", true},
+		{float32(0.5), "0.5", true},
+		{map[string]any{"id": int64(9007199254740993)}, map[string]any{"id": "9007199254740992"}, false},
+	} {
+		if got := Equal(test.val, test.expected); got != test.equal {
+			t.Errorf("Equal(%#v, %#v) = %v, want %v", test.val, test.expected, got, test.equal)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	actual := Normalize(map[string]any{
+		"id":    int64(1),
+		"name":  "a",
+		"extra": true,
+		"items": []any{map[string]any{"n": int32(1)}, map[string]any{"n": int32(2)}},
+	})
+	expected := Normalize(map[string]any{
+		"id":    "1",
+		"name":  "b",
+		"gone":  int64(3),
+		"items": []any{map[string]any{"n": int64(1)}, map[string]any{"n": int64(5)}},
+	})
+
+	partial := Diff(actual, expected, true)
+	wantPartial := []Difference{
+		{Path: "gone", Expected: int64(3), Missing: true},
+		{Path: "items[1].n", Expected: int64(5), Actual: int64(2)},
+		{Path: "name", Expected: "b", Actual: "a"},
+	}
+	if !reflect.DeepEqual(partial, wantPartial) {
+		t.Errorf("partial Diff = %#v, want %#v", partial, wantPartial)
+	}
+
+	full := Diff(actual, expected, false)
+	wantFull := append(wantPartial, Difference{Path: "extra", Actual: true, Unexpected: true})
+	if !reflect.DeepEqual(full, wantFull) {
+		t.Errorf("Diff = %#v, want %#v", full, wantFull)
+	}
+
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+	formatted := FormatDiff(append(wantFull, Difference{Expected: 1.0, Actual: "x"}))
+	want := `  - gone: missing, expected 3
+  ~ items[1].n: expected 5 but got 2
+  ~ name: expected "b" but got "a"
+  + extra: unexpected true
+  ~ (value): expected 1 but got "x"`
+	if formatted != want {
+		t.Errorf("FormatDiff =\n%s\nwant\n%s", formatted, want)
+	}
+}
+
+func TestStructuralMatch(t *testing.T) {
+	actual := map[string]any{"id": int64(1), "name": "a"}
+	partial, err := DataFunction("matchesPartial", []any{map[string]any{"id": int64(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := partial(actual); err != nil {
+		t.Errorf("matchesPartial: %v", err)
+	}
+	deep, err := DataFunction("deepEquals", []any{map[string]any{"id": int64(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deep(actual); err == nil {
+		t.Errorf("deepEquals passed with an unexpected field")
+	}
+}
@@ -0,0 +1,154 @@
+package functions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ToTime converts a time or a Timestamp in its canonical RFC 3339 form.
+func ToTime(val any) (time.Time, bool) {
+	switch t := val.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// ToDuration converts a Duration in its canonical form, "1.5s", or any Go
+// duration such as "100ms" or "2m".
+func ToDuration(val any) (time.Duration, bool) {
+	if d, ok := val.(time.Duration); ok {
+		return d, true
+	}
+	str, ok := val.(string)
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(str)
+	return d, err == nil
+}
+
+// durationArgs converts count arguments which are all durations, a number
+// is not one even when it is a string such as "0".
+func durationArgs(args []any, count int) ([]time.Duration, bool) {
+	if len(args) != count {
+		return nil, false
+	}
+	durations := make([]time.Duration, count)
+	for i, arg := range args {
+		if _, isNum := ToNumber(arg); isNum {
+			return nil, false
+		}
+		d, ok := ToDuration(arg)
+		if !ok {
+			return nil, false
+		}
+		durations[i] = d
+	}
+	return durations, true
+}
+
+func compareDuration(relation string, compare func(val, arg float64) bool, arg time.Duration) dataFunction {
+	return func(val any) error {
+		d, ok := ToDuration(val)
+		if !ok {
+			return fmt.Errorf("expected to be a duration %s %v but got \"%v\"", relation, arg, val)
+		}
+		if !compare(float64(d), float64(arg)) {
+			return fmt.Errorf("expected to be %s %v but got %v", relation, arg, d)
+		}
+		return nil
+	}
+}
+
+func timeArg(args []any, i int) (time.Time, error) {
+	t, ok := ToTime(args[i])
+	if !ok {
+		return time.Time{}, fmt.Errorf("argument %d must be a time such as now() or \"2006-01-02T15:04:05Z\" but got \"%v\"", i+1, args[i])
+	}
+	return t, nil
+}
+
+func compareTime(relation string, compare func(val, arg time.Time) bool) dataFunctionBuilder {
+	return func(args []any) (dataFunction, error) {
+		if err := checkArgs(args, 1); err != nil {
+			return nil, err
+		}
+		arg, err := timeArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return func(val any) error {
+			t, ok := ToTime(val)
+			if !ok {
+				return fmt.Errorf("expected to be a time %s %s but got \"%v\"", relation, arg.Format(time.RFC3339Nano), val)
+			}
+			if !compare(t, arg) {
+				return fmt.Errorf("expected to be %s %s but got %s", relation, arg.Format(time.RFC3339Nano), t.Format(time.RFC3339Nano))
+			}
+			return nil
+		}, nil
+	}
+}
+
+// isWithin("5s") passes for a time at most 5s away from now, or from the
+// time of the second argument, and for a duration of at most 5s.
+func isWithin(args []any) (dataFunction, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("expects 1 or 2 argument(s) but got %d", len(args))
+	}
+	within, ok := ToDuration(args[0])
+	if !ok {
+		return nil, fmt.Errorf("argument 1 must be a duration such as \"5s\" but got \"%v\"", args[0])
+	}
+	var reference *time.Time
+	if len(args) == 2 {
+		t, err := timeArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		reference = &t
+	}
+	return func(val any) error {
+		if t, ok := ToTime(val); ok {
+			from := time.Now()
+			if reference != nil {
+				from = *reference
+			}
+			if distance := t.Sub(from); distance > within || distance < -within {
+				return fmt.Errorf("expected to be within %v of %s but got %s", within, from.Format(time.RFC3339Nano), t.Format(time.RFC3339Nano))
+			}
+			return nil
+		}
+		if d, ok := ToDuration(val); ok && reference == nil {
+			if d > within || d < -within {
+				return fmt.Errorf("expected to be a duration within %v but got %v", within, d)
+			}
+			return nil
+		}
+		return fmt.Errorf("expected to be a time or a duration within %v but got \"%v\"", within, val)
+	}, nil
+}
+
+// isAnyOf("pkg.Type") passes for an Any message packing a pkg.Type.
+func isAnyOf(args []any) (dataFunction, error) {
+	typeName, err := stringArg(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(val any) error {
+		packed, ok := val.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected to be an Any of %s but got \"%v\"", typeName, val)
+		}
+		typeURL, _ := packed["@type"].(string)
+		if typeURL[strings.LastIndex(typeURL, "/")+1:] != typeName {
+			return fmt.Errorf("expected to be an Any of %s but got \"%s\"", typeName, typeURL)
+		}
+		return nil
+	}, nil
+}
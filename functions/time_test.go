@@ -0,0 +1,90 @@
+package functions
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToDuration(t *testing.T) {
+	for _, test := range []struct {
+		val  any
+		want time.Duration
+		ok   bool
+	}{
+		{"1.500s", 1500 * time.Millisecond, true},
+		{"200ms", 200 * time.Millisecond, true},
+		{"2m", 2 * time.Minute, true},
+		{time.Second, time.Second, true},
+		{"soon", 0, false},
+		{int64(1), 0, false},
+	} {
+		got, ok := ToDuration(test.val)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ToDuration(%v) = %v, %v, want %v, %v", test.val, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDurationArgs(t *testing.T) {
+	for _, test := range []struct {
+		args []any
+		want []time.Duration
+		ok   bool
+	}{
+		{[]any{"1s"}, []time.Duration{time.Second}, true},
+		{[]any{"1s", "2s"}, []time.Duration{time.Second, 2 * time.Second}, true},
+		{[]any{"0"}, nil, false},
+		{[]any{int64(1)}, nil, false},
+		{[]any{"1s", int64(2)}, nil, false},
+	} {
+		got, ok := durationArgs(test.args, len(test.args))
+		if ok != test.ok || ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("durationArgs(%v) = %v, %v, want %v, %v", test.args, got, ok, test.want, test.ok)
+		}
+	}
+	if _, ok := durationArgs([]any{"1s"}, 2); ok {
+		t.Errorf("durationArgs took 1 argument for 2")
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	now := time.Now()
+	at := "2020-01-01T00:00:00Z"
+	for _, test := range []struct {
+		fn   string
+		args []any
+		val  any
+		pass bool
+	}{
+		{"isBefore", []any{"2021-01-01T00:00:00Z"}, at, true},
+		{"isBefore", []any{"2019-01-01T00:00:00Z"}, at, false},
+		{"isBefore", []any{now}, at, true},
+		{"isAfter", []any{"2019-12-31T23:59:59.999Z"}, at, true},
+		{"isAfter", []any{at}, at, false},
+		{"isAfter", []any{at}, "not a time", false},
+		{"isWithin", []any{"5s"}, now.Add(-2 * time.Second).Format(time.RFC3339Nano), true},
+		{"isWithin", []any{"5s"}, now.Add(time.Minute).Format(time.RFC3339Nano), false},
+		{"isWithin", []any{"1h", at}, "2020-01-01T00:30:00Z", true},
+		{"isWithin", []any{"1h", at}, "2020-01-01T02:00:00Z", false},
+		{"isWithin", []any{"1s"}, "0.500s", true},
+		{"isWithin", []any{"1s"}, "1.500s", false},
+		{"isWithin", []any{"1s", at}, "0.500s", false},
+		{"isLessThan", []any{"200ms"}, "0.150s", true},
+		{"isLessThan", []any{"200ms"}, 250 * time.Millisecond, false},
+		{"isGreaterThan", []any{"1s"}, "1m", true},
+		{"isGreaterThan", []any{"1s"}, int64(5), false},
+		{"isBetween", []any{"1s", "2s"}, "1.500s", true},
+		{"isBetween", []any{"1s", "2s"}, 3 * time.Second, false},
+		{"isBetween", []any{"1s", "2s"}, "x", false},
+	} {
+		fn, err := DataFunction(test.fn, test.args)
+		if err != nil {
+			t.Errorf("%s%v: unexpected error %v", test.fn, test.args, err)
+			continue
+		}
+		if err := fn(test.val); (err == nil) != test.pass {
+			t.Errorf("%s%v on %v: error %v, want pass %v", test.fn, test.args, test.val, err, test.pass)
+		}
+	}
+}
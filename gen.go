@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trpc/grpcrunner"
+
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+)
+
+const (
+	genEndpoint = "server"
+	genTarget   = "localhost:50051"
+)
+
+type genCmd struct {
+	Symbols            []string `arg optional help:"Services or methods to generate invokes for, package.Service or package.Service/Method. Every service is generated when none is given."`
+	Target             string   `help:"Address of a server with reflection, host:port, which is the endpoint of the invokes as well."`
+	Tls                bool     `help:"Connect to the target by TLS."`
+	Cacert             string   `help:"CA certificate to verify the target with, implies tls."`
+	Cert               string   `help:"Client certificate for mTLS, with --key, implies tls."`
+	Key                string   `help:"Client key for mTLS, with --cert."`
+	InsecureSkipVerify bool     `help:"Do not verify the certificate of the target, implies tls."`
+	Authority          string   `help:"Value of the :authority pseudo header."`
+	ServerName         string   `help:"Name to verify the certificate of the target against, implies tls."`
+	Protoset           []string `help:"Protoset(s) of the services, imported by the generated file."`
+	Proto              []string `help:"Proto file(s) of the services, imported by the generated file."`
+	ImportPath         []string `help:"Import path(s) of the proto files."`
+	Output             string   `short:"o" help:"File to write the skeleton to rather than printing it, an existing file is not overwritten."`
+}
+
+func (cmd *genCmd) Run() error {
+	if cmd.Output != "" {
+		if _, err := os.Stat(cmd.Output); err == nil {
+			return trpcError{ExitCode: 1, Msg: fmt.Sprintf("%s exists, gen does not overwrite files", cmd.Output)}
+		}
+	}
+	params := grpcrunner.RunParams{
+		Target:      cmd.Target,
+		Plaintext:   !cmd.tls(),
+		Insecure:    cmd.InsecureSkipVerify,
+		CACert:      cmd.Cacert,
+		Cert:        cmd.Cert,
+		Key:         cmd.Key,
+		Authority:   cmd.Authority,
+		ServerName:  cmd.ServerName,
+		Protoset:    cmd.Protoset,
+		ProtoFiles:  cmd.Proto,
+		ImportPaths: cmd.ImportPath,
+	}
+	source, closeSource, err := grpcrunner.Source(params)
+	if err != nil {
+		return trpcError{ExitCode: 1, Msg: err.Error()}
+	}
+	defer closeSource()
+
+	symbols := cmd.Symbols
+	if len(symbols) == 0 {
+		services, err := source.ListServices()
+		if err != nil {
+			return trpcError{ExitCode: 1, Msg: fmt.Sprintf("Failed to list services: %v", err)}
+		}
+		// proto files list their services in no particular order
+		sort.Strings(services)
+		for _, service := range services {
+			if !strings.HasPrefix(service, "grpc.reflection.") {
+				symbols = append(symbols, service)
+			}
+		}
+		if len(symbols) == 0 {
+			return trpcError{ExitCode: 1, Msg: "No services found"}
+		}
+	}
+	methods := make([]*desc.MethodDescriptor, 0)
+	for _, symbol := range symbols {
+		name := strings.ReplaceAll(strings.TrimPrefix(symbol, "."), "/", ".")
+		dsc, err := source.FindSymbol(name)
+		if err != nil {
+			return trpcError{ExitCode: 1, Msg: fmt.Sprintf("Failed to resolve %s: %v", symbol, err)}
+		}
+		switch d := dsc.(type) {
+		case *desc.ServiceDescriptor:
+			methods = append(methods, d.GetMethods()...)
+		case *desc.MethodDescriptor:
+			methods = append(methods, d)
+		default:
+			return trpcError{ExitCode: 1, Msg: fmt.Sprintf("%s is not a service or method", symbol)}
+		}
+	}
+
+	skeleton, err := cmd.skeleton(symbols, methods)
+	if err != nil {
+		return trpcError{ExitCode: 1, Msg: err.Error()}
+	}
+	if cmd.Output == "" {
+		os.Stdout.Write(skeleton)
+		return nil
+	}
+	if err := os.WriteFile(cmd.Output, skeleton, 0644); err != nil {
+		return trpcError{ExitCode: 1, Msg: err.Error()}
+	}
+	return nil
+}
+
+// tls tells the endpoint is TLS, its options imply it as they do on endpoints.
+func (cmd *genCmd) tls() bool {
+	return cmd.Tls || impliesTls(cmd.Cacert, cmd.Cert, cmd.InsecureSkipVerify, cmd.ServerName)
+}
+
+// skeleton writes a file testing the methods, which is formatted in the
+// canonical form by parsing it back.
+func (cmd *genCmd) skeleton(symbols []string, methods []*desc.MethodDescriptor) ([]byte, error) {
+	var src strings.Builder
+	fmt.Fprintf(&src, "test %s\n", strconv.Quote(strings.Join(symbols, ", ")))
+	fmt.Fprintf(&src, "desc %s\n", strconv.Quote("Generated by trpc gen"))
+	fmt.Fprintf(&src, "trpc %s\n", strconv.Quote(acceptableVersion))
+	for _, importPath := range cmd.ImportPath {
+		fmt.Fprintf(&src, "importpath %s\n", strconv.Quote(importPath))
+	}
+	for _, protoFile := range cmd.Proto {
+		fmt.Fprintf(&src, "import protofile %s\n", strconv.Quote(protoFile))
+	}
+	for _, protoset := range cmd.Protoset {
+		fmt.Fprintf(&src, "import protoset %s\n", strconv.Quote(protoset))
+	}
+	endpoint, err := cmd.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	src.WriteString(endpoint)
+
+	names := make(map[string]bool)
+	for _, method := range methods {
+		src.WriteString(invokeSkeleton(invokeName(method, names), method))
+	}
+
+	trpc := &Trpc{}
+	if err := parser.Parse("gen.trpc", strings.NewReader(src.String()), trpc); err != nil {
+		return nil, fmt.Errorf("Generated an invalid file: %v\n%s", err, src.String())
+	}
+	formatted := formatTrpc(trpc, []byte(src.String()))
+	return bytes.TrimLeft(formatted, "\n"), nil
+}
+
+func (cmd *genCmd) endpoint() (string, error) {
+	target := cmd.Target
+	placeholder := target == ""
+	if placeholder {
+		target = genTarget
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", fmt.Errorf("Invalid target %s: %v", target, err)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("The port of the target %s must be a number", target)
+	}
+	var endpoint strings.Builder
+	if placeholder {
+		endpoint.WriteString("// the address of the server\n")
+	}
+	endpoint.WriteString("endpoint " + genEndpoint)
+	if cmd.tls() {
+		endpoint.WriteString(" tls")
+	}
+	fmt.Fprintf(&endpoint, " %s port %s", strconv.Quote(host), port)
+	if cmd.Cacert != "" {
+		fmt.Fprintf(&endpoint, " cacert %s", strconv.Quote(cmd.Cacert))
+	}
+	if cmd.Cert != "" {
+		fmt.Fprintf(&endpoint, " cert %s key %s", strconv.Quote(cmd.Cert), strconv.Quote(cmd.Key))
+	}
+	if cmd.InsecureSkipVerify {
+		endpoint.WriteString(" insecureSkipVerify")
+	}
+	if cmd.Authority != "" {
+		fmt.Fprintf(&endpoint, " authority %s", strconv.Quote(cmd.Authority))
+	}
+	if cmd.ServerName != "" {
+		fmt.Fprintf(&endpoint, " serverName %s", strconv.Quote(cmd.ServerName))
+	}
+	return endpoint.String() + "\n", nil
+}
+
+// invokeName names the invoke of a method by the method, the service is added
+// when another service has a method of the same name.
+func invokeName(method *desc.MethodDescriptor, names map[string]bool) string {
+	name := lowerFirst(method.GetName())
+	if names[name] {
+		name = lowerFirst(method.GetService().GetName()) + method.GetName()
+	}
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+	}
+	names[name] = true
+	return name
+}
+
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// invokeSkeleton is an invoke of the method with a value for every field of
+// its request, sent as a stream or an exchange by streaming methods.
+func invokeSkeleton(name string, method *desc.MethodDescriptor) string {
+	var invoke strings.Builder
+	fmt.Fprintf(&invoke, "invoke %s %s %s %s", name, genEndpoint, method.GetService().GetFullyQualifiedName(), method.GetName())
+	input := method.GetInputType()
+	switch {
+	case method.IsClientStreaming() && method.IsServerStreaming():
+		fmt.Fprintf(&invoke, " exchange {\nsend %s\n}", templateMessage(input, nil))
+	case method.IsClientStreaming():
+		fmt.Fprintf(&invoke, " stream [\n%s\n]", templateMessage(input, nil))
+	default:
+		if fields := templateFields(input, nil); len(fields) > 0 {
+			fmt.Fprintf(&invoke, " data {\n%s\n}", strings.Join(fields, "\n"))
+		}
+	}
+	invoke.WriteString(" expects {\ncode isOk()\n}\n")
+	return invoke.String()
+}
+
+// templateFields are the fields of md with a value of their type, only the
+// first field of a oneof is set as the request can not set more of them.
+func templateFields(md *desc.MessageDescriptor, path []*desc.MessageDescriptor) []string {
+	path = append(path, md)
+	fields := make([]string, 0)
+	oneofs := make(map[*desc.OneOfDescriptor]bool)
+	for _, field := range md.GetFields() {
+		if oneof := field.GetOneOf(); oneof != nil {
+			if oneofs[oneof] {
+				continue
+			}
+			oneofs[oneof] = true
+		}
+		fields = append(fields, field.GetName()+": "+templateValue(field, path))
+	}
+	return fields
+}
+
+func templateValue(field *desc.FieldDescriptor, path []*desc.MessageDescriptor) string {
+	if field.IsMap() {
+		// keys of objects are identifiers so only string keys are shown
+		if field.GetMapKeyType().GetType() != dpb.FieldDescriptorProto_TYPE_STRING {
+			return "{}"
+		}
+		return "{key: " + templateItem(field.GetMapValueType(), path) + "}"
+	}
+	if field.IsRepeated() {
+		return "[" + templateItem(field, path) + "]"
+	}
+	return templateItem(field, path)
+}
+
+// templateItem is a value of the type of the field, an enum takes its first
+// value.
+func templateItem(field *desc.FieldDescriptor, path []*desc.MessageDescriptor) string {
+	switch field.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		return templateMessage(field.GetMessageType(), path)
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		return strconv.Quote(field.GetEnumType().GetValues()[0].GetName())
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		return "false"
+	case dpb.FieldDescriptorProto_TYPE_STRING, dpb.FieldDescriptorProto_TYPE_BYTES:
+		return `""`
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE, dpb.FieldDescriptorProto_TYPE_FLOAT:
+		return "0.0"
+	}
+	return "0"
+}
+
+// templateMessage is an object of md, well known types take their canonical
+// JSON form and a message within itself is left empty.
+func templateMessage(md *desc.MessageDescriptor, path []*desc.MessageDescriptor) string {
+	switch md.GetFullyQualifiedName() {
+	case "google.protobuf.Timestamp":
+		return "now()"
+	case "google.protobuf.Duration":
+		return `"1s"`
+	case "google.protobuf.FieldMask", "google.protobuf.Value":
+		return `""`
+	case "google.protobuf.ListValue":
+		return "[]"
+	case "google.protobuf.Struct", "google.protobuf.Any", "google.protobuf.Empty":
+		return "{}"
+	}
+	if wrapped := wrappedField(md); wrapped != nil {
+		return templateItem(wrapped, path)
+	}
+	for _, seen := range path {
+		if seen == md {
+			return "{}"
+		}
+	}
+	return "{" + strings.Join(templateFields(md, path), ", ") + "}"
+}
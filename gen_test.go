@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenSkeleton(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "gen.trpc")
+	cmd := &genCmd{
+		Proto:      []string{"gen.proto", "other.proto", "third.proto"},
+		ImportPath: []string{filepath.Join("testdata", "gen")},
+		Output:     output,
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	skeleton, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// names of colliding methods, recursive messages, well known types, maps
+	// and streaming methods
+	golden(t, "gen.golden", skeleton)
+
+	trpc, err := parseFile(output)
+	if err != nil {
+		t.Fatalf("the skeleton does not parse: %v", err)
+	}
+	var problems int
+	printed := captureOutput(t, func() { problems = checkFile(trpc, "") })
+	if problems != 0 {
+		t.Errorf("check finds %d problem(s) in the skeleton:\n%s", problems, printed)
+	}
+
+	err = cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "gen does not overwrite files") {
+		t.Errorf("gen to an existing file: error %v", err)
+	}
+}
+
+func TestGenSymbols(t *testing.T) {
+	for _, test := range []struct {
+		symbols []string
+		invokes []string
+		err     string
+	}{
+		{[]string{"gen.Orders"}, []string{"invoke get server gen.Orders Get"}, ""},
+		{[]string{"gen.Users/Ping", "other.Users.Ping"}, []string{"invoke ping server gen.Users Ping", "invoke usersPing server other.Users Ping"}, ""},
+		{[]string{"gen.Nope"}, nil, "Failed to resolve gen.Nope"},
+		{[]string{"gen.Request"}, nil, "gen.Request is not a service or method"},
+	} {
+		output := filepath.Join(t.TempDir(), "gen.trpc")
+		cmd := &genCmd{
+			Symbols:    test.symbols,
+			Proto:      []string{"gen.proto", "other.proto"},
+			ImportPath: []string{filepath.Join("testdata", "gen")},
+			Output:     output,
+		}
+		err := cmd.Run()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("gen %v: error %v, want %q", test.symbols, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("gen %v: %v", test.symbols, err)
+			continue
+		}
+		skeleton, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invokes := strings.Count(string(skeleton), "invoke "); invokes != len(test.invokes) {
+			t.Errorf("gen %v: %d invoke(s), want %d:\n%s", test.symbols, invokes, len(test.invokes), skeleton)
+		}
+		for _, invoke := range test.invokes {
+			if !strings.Contains(string(skeleton), invoke) {
+				t.Errorf("gen %v does not contain %q:\n%s", test.symbols, invoke, skeleton)
+			}
+		}
+	}
+}
+
+func TestGenTls(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "gen.trpc")
+	cmd := &genCmd{
+		Symbols:            []string{"gen.Orders"},
+		Proto:              []string{"gen.proto"},
+		ImportPath:         []string{filepath.Join("testdata", "gen")},
+		Output:             output,
+		InsecureSkipVerify: true,
+		ServerName:         "orders.internal",
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	skeleton, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the TLS options imply tls on the endpoint without --tls
+	golden(t, "gen_tls.golden", skeleton)
+}
@@ -0,0 +1,143 @@
+package grpcrunner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// List lists the services of the source of the params, or the methods of the
+// service when one is given, by their fully qualified names.
+func List(params RunParams, service string) ([]string, error) {
+	source, closeSource, err := Source(params)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+	if service == "" {
+		services, err := grpcurl.ListServices(source)
+		if err != nil {
+			return nil, fail(err, "Failed to list services")
+		}
+		return services, nil
+	}
+	methods, err := grpcurl.ListMethods(source, strings.TrimPrefix(service, "."))
+	if err != nil {
+		return nil, fail(err, "Failed to list methods for service %q", service)
+	}
+	return methods, nil
+}
+
+// Describe writes the descriptors of the symbols in the proto syntax, every
+// service of the source of the params when there are none. A method is
+// followed by its request and response messages and with MsgTemplate every
+// message is followed by a template of it in JSON.
+func Describe(w io.Writer, params RunParams, symbols ...string) error {
+	source, closeSource, err := Source(params)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+	if len(symbols) == 0 {
+		services, err := source.ListServices()
+		if err != nil {
+			return fail(err, "Failed to list services")
+		}
+		if len(services) == 0 {
+			fmt.Fprintln(w, "(No services)")
+		}
+		// protosets and proto files list their services in no particular order
+		sort.Strings(services)
+		symbols = services
+	}
+
+	described := make(map[string]bool)
+	for i := 0; i < len(symbols); i++ {
+		s := strings.ReplaceAll(strings.TrimPrefix(symbols[i], "."), "/", ".")
+		dsc, err := source.FindSymbol(s)
+		if err != nil {
+			return fail(err, "Failed to resolve symbol %q", s)
+		}
+		fqn := dsc.GetFullyQualifiedName()
+		if described[fqn] {
+			continue
+		}
+		described[fqn] = true
+		if len(described) > 1 {
+			fmt.Fprintln(w)
+		}
+
+		var elementType string
+		switch d := dsc.(type) {
+		case *desc.MessageDescriptor:
+			elementType = "a message"
+			if parent, ok := d.GetParent().(*desc.MessageDescriptor); ok {
+				for _, f := range parent.GetFields() {
+					if f.GetMessageType() != d {
+						continue
+					}
+					// describe the map or group field instead
+					if d.IsMapEntry() && f.IsMap() {
+						elementType = "the entry type for a map field"
+						dsc = f
+						break
+					}
+					if f.GetType() == descriptor.FieldDescriptorProto_TYPE_GROUP {
+						elementType = "the type of a group field"
+						dsc = f
+						break
+					}
+				}
+			}
+		case *desc.FieldDescriptor:
+			elementType = "a field"
+			if d.GetType() == descriptor.FieldDescriptorProto_TYPE_GROUP {
+				elementType = "a group field"
+			} else if d.IsExtension() {
+				elementType = "an extension"
+			}
+		case *desc.OneOfDescriptor:
+			elementType = "a one-of"
+		case *desc.EnumDescriptor:
+			elementType = "an enum"
+		case *desc.EnumValueDescriptor:
+			elementType = "an enum value"
+		case *desc.ServiceDescriptor:
+			elementType = "a service"
+		case *desc.MethodDescriptor:
+			elementType = "a method"
+			symbols = append(symbols, d.GetInputType().GetFullyQualifiedName(), d.GetOutputType().GetFullyQualifiedName())
+		default:
+			return fail(fmt.Errorf("descriptor has unrecognized type %T", dsc), "Failed to describe symbol %q", s)
+		}
+
+		txt, err := grpcurl.GetDescriptorText(dsc, source)
+		if err != nil {
+			return fail(err, "Failed to describe symbol %q", s)
+		}
+		fmt.Fprintf(w, "%s is %s:\n", fqn, elementType)
+		fmt.Fprintln(w, txt)
+
+		if md, ok := dsc.(*desc.MessageDescriptor); ok && params.MsgTemplate {
+			// a template in JSON makes it easier to write the data of an invoke
+			tmpl := grpcurl.MakeTemplate(md)
+			options := grpcurl.FormatOptions{EmitJSONDefaultFields: true}
+			_, formatter, err := grpcurl.RequestParserAndFormatter(grpcurl.Format("json"), source, strings.NewReader(""), options)
+			if err != nil {
+				return fail(err, "Failed to construct formatter for json")
+			}
+			str, err := formatter(tmpl)
+			if err != nil {
+				return fail(err, "Failed to print template for message %s", s)
+			}
+			fmt.Fprintln(w, "\nMessage template:")
+			fmt.Fprintln(w, str)
+		}
+	}
+	return nil
+}
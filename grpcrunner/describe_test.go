@@ -0,0 +1,95 @@
+package grpcrunner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+const describeProto = `syntax = "proto3";
+package shop;
+
+message Item {
+  string id = 1;
+  map<string, int32> counts = 2;
+}
+
+service Shop {
+  rpc Get(Item) returns (Item);
+}
+
+service Admin {
+  rpc Reset(Item) returns (Item);
+}
+`
+
+// writeProtoset writes the protoset of describeProto and returns its path.
+func writeProtoset(t *testing.T) string {
+	t.Helper()
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"shop.proto": describeProto}),
+	}
+	files, err := parser.ParseFiles("shop.proto")
+	if err != nil {
+		t.Fatalf("parse shop.proto: %v", err)
+	}
+	protoset, err := proto.Marshal(desc.ToFileDescriptorSet(files...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "shop.protoset")
+	if err := os.WriteFile(path, protoset, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDescribe(t *testing.T) {
+	params := RunParams{Protoset: []string{writeProtoset(t)}}
+	for _, test := range []struct {
+		symbols  []string
+		template bool
+		want     string
+	}{
+		// every service, by name
+		{nil, false, "shop.Admin is a service:\nservice Admin {\n  rpc Reset ( .shop.Item ) returns ( .shop.Item );\n}\n\n" +
+			"shop.Shop is a service:\nservice Shop {\n  rpc Get ( .shop.Item ) returns ( .shop.Item );\n}\n"},
+		// a method is followed by its messages, once
+		{[]string{"shop.Shop/Get"}, false, "shop.Shop.Get is a method:\nrpc Get ( .shop.Item ) returns ( .shop.Item );\n\n" +
+			"shop.Item is a message:\nmessage Item {\n  string id = 1;\n  map<string, int32> counts = 2;\n}\n"},
+		{[]string{".shop.Item.CountsEntry"}, false, "shop.Item.CountsEntry is the entry type for a map field:\nmap<string, int32> counts = 2;\n"},
+		{[]string{"shop.Item"}, true, "shop.Item is a message:\nmessage Item {\n  string id = 1;\n  map<string, int32> counts = 2;\n}\n\n" +
+			"Message template:\n{\n  \"id\": \"\",\n  \"counts\": {\n    \"\": 0\n  }\n}\n"},
+	} {
+		var out strings.Builder
+		params.MsgTemplate = test.template
+		if err := Describe(&out, params, test.symbols...); err != nil {
+			t.Errorf("Describe(%v): %v", test.symbols, err)
+			continue
+		}
+		if got := out.String(); got != test.want {
+			t.Errorf("Describe(%v) =\n%s\nwant:\n%s", test.symbols, got, test.want)
+		}
+	}
+}
+
+func TestDescribeErrors(t *testing.T) {
+	for _, test := range []struct {
+		params  RunParams
+		symbols []string
+		err     string
+	}{
+		{RunParams{Protoset: []string{writeProtoset(t)}}, []string{"shop.Nope"}, `Failed to resolve symbol "shop.Nope"`},
+		{RunParams{}, nil, "No target to ask by reflection and no protoset or proto files."},
+	} {
+		err := Describe(&strings.Builder{}, test.params, test.symbols...)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Describe(%v): error %v, want %q", test.symbols, err, test.err)
+		}
+	}
+}
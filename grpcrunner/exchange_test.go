@@ -0,0 +1,103 @@
+package grpcrunner
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	grpcreflection "google.golang.org/grpc/reflection"
+)
+
+// exchangeServer replies to the messages of a full duplex call, message i
+// gets replies[i] replies.
+type exchangeServer struct {
+	testpb.UnimplementedTestServiceServer
+	replies []int
+}
+
+func (s *exchangeServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for i := 0; ; i++ {
+		if _, err := stream.Recv(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for j := 0; i < len(s.replies) && j < s.replies[i]; j++ {
+			if err := stream.Send(&testpb.StreamingOutputCallResponse{}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// serveTest serves the test service with reflection on a local port.
+func serveTest(t *testing.T, service testpb.TestServiceServer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, service)
+	grpcreflection.Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func runExchange(t *testing.T, replies []int, messages int) (*TRPCHandler, error) {
+	target := serveTest(t, &exchangeServer{replies: replies})
+	stream := make([]map[string]interface{}, messages)
+	for i := range stream {
+		stream[i] = map[string]interface{}{}
+	}
+	return Run(RunParams{
+		Target:      target,
+		Plaintext:   true,
+		ServiceName: "grpc.testing.TestService",
+		MethodName:  "FullDuplexCall",
+		Stream:      stream,
+		Lockstep:    true,
+		FormatError: true,
+	})
+}
+
+func TestLockstepExchange(t *testing.T) {
+	handler, err := runExchange(t, []int{1, 1, 1}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 2, 3}
+	if len(handler.SentBeforeResponse) != len(want) {
+		t.Fatalf("SentBeforeResponse = %v, want %v", handler.SentBeforeResponse, want)
+	}
+	for i, sent := range handler.SentBeforeResponse {
+		if sent != want[i] {
+			t.Errorf("reply %d arrived after %d message(s), want %d", i+1, sent, want[i])
+		}
+	}
+}
+
+func TestLockstepExchangeMissingReply(t *testing.T) {
+	wait := exchangeReplyWait
+	exchangeReplyWait = 200 * time.Millisecond
+	defer func() { exchangeReplyWait = wait }()
+
+	for _, test := range []struct {
+		name    string
+		replies []int
+		err     string
+	}{
+		{"no reply", []int{0}, "no reply to message 1 of the exchange"},
+		// the extra reply to message 1 is not the reply to message 2
+		{"extra reply", []int{2, 0}, "no reply to message 2 of the exchange"},
+	} {
+		_, err := runExchange(t, test.replies, 3)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
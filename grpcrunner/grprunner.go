@@ -13,8 +13,10 @@ import (
 	//"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/grpcreflect"
 	"google.golang.org/grpc"
@@ -44,6 +46,10 @@ const no_version = "beta build"
 
 var version = no_version
 
+// exchangeReplyWait is how long a lockstep exchange waits for the reply of a
+// message without MaxTime.
+var exchangeReplyWait = 10 * time.Second
+
 type RunParams struct {
 	//server:port
 	Target    string
@@ -68,6 +74,16 @@ type RunParams struct {
 	MethodName  string
 	UserAgent   string
 	Data        map[string]interface{}
+	// Stream holds the request messages of a client or bidirectional stream,
+	// when not nil Data is ignored, an empty Stream sends no message at all.
+	Stream []map[string]interface{}
+	// Lockstep makes a bidirectional stream wait for a reply before sending
+	// the next message.
+	Lockstep bool
+	// Session shares connections and descriptor sources with other runs,
+	// unless Fresh is set. When nil the run dials its own connection.
+	Session *Session
+	Fresh   bool
 
 	AllowUnknownFields bool
 
@@ -103,8 +119,6 @@ type RunParams struct {
 }
 
 var (
-	exit = os.Exit
-
 	isUnixSocket func() bool // nil when run on non-unix platform
 
 	//formatError = flags.Bool("format-error", false, prettify(`
@@ -226,44 +240,30 @@ func Run(params RunParams) (*TRPCHandler, error) {
 
 	// Do extra validation on arguments and figure out what user asked us to do.
 	if params.ConnectTimeout < 0 {
-		fail(nil, "The -connect-timeout argument must not be negative.")
+		return nil, fail(nil, "The -connect-timeout argument must not be negative.")
 	}
 	if params.KeepaliveTime < 0 {
-		fail(nil, "The -keepalive-time argument must not be negative.")
+		return nil, fail(nil, "The -keepalive-time argument must not be negative.")
 	}
 	if params.MaxTime < 0 {
-		fail(nil, "The -max-time argument must not be negative.")
+		return nil, fail(nil, "The -max-time argument must not be negative.")
 	}
 	if params.MaxMessagSize < 0 {
-		fail(nil, "The -max-msg-sz argument must not be negative.")
+		return nil, fail(nil, "The -max-msg-sz argument must not be negative.")
 	}
 	if params.Plaintext && params.Insecure {
-		fail(nil, "The -plaintext and -insecure arguments are mutually exclusive.")
+		return nil, fail(nil, "The -plaintext and -insecure arguments are mutually exclusive.")
 	}
 	if params.Plaintext && params.Cert != "" {
-		fail(nil, "The -plaintext and -cert arguments are mutually exclusive.")
+		return nil, fail(nil, "The -plaintext and -cert arguments are mutually exclusive.")
 	}
 	if params.Plaintext && params.Key != "" {
-		fail(nil, "The -plaintext and -key arguments are mutually exclusive.")
+		return nil, fail(nil, "The -plaintext and -key arguments are mutually exclusive.")
 	}
 	if (params.Key == "") != (params.Cert == "") {
-		fail(nil, "The -cert and -key arguments must be used together and both be present.")
+		return nil, fail(nil, "The -cert and -key arguments must be used together and both be present.")
 	}
 
-	//var list, describe, invoke bool
-	//list = false
-	//describe = false
-	//invoke = true
-	//if args[0] == "list" {
-	//	list = true
-	//	args = args[1:]
-	//} else if args[0] == "describe" {
-	//	describe = true
-	//	args = args[1:]
-	//} else {
-	//	invoke = true
-	//}
-
 	verbosityLevel := 0
 	if params.Verbose {
 		verbosityLevel = 1
@@ -315,8 +315,9 @@ func Run(params RunParams) (*TRPCHandler, error) {
 	//}
 
 	//// Protoset or protofiles provided and -use-reflection unset
+	useReflection := reflection.val
 	if !reflection.set && (len(params.Protoset) > 0 || len(params.ProtoFiles) > 0) {
-		reflection.val = false
+		useReflection = false
 	}
 
 	ctx := context.Background()
@@ -327,83 +328,8 @@ func Run(params RunParams) (*TRPCHandler, error) {
 		defer cancel()
 	}
 
-	dial := func() *grpc.ClientConn {
-		dialTime := 10 * time.Second
-		if params.ConnectTimeout > 0 {
-			dialTime = time.Duration(params.ConnectTimeout * float64(time.Second))
-		}
-		ctx, cancel := context.WithTimeout(ctx, dialTime)
-		defer cancel()
-		var opts []grpc.DialOption
-		if params.KeepaliveTime > 0 {
-			timeout := time.Duration(params.KeepaliveTime * float64(time.Second))
-			opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
-				Time:    timeout,
-				Timeout: timeout,
-			}))
-		}
-		if params.MaxMessagSize > 0 {
-			opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(params.MaxMessagSize)))
-		}
-		var creds credentials.TransportCredentials
-		if !params.Plaintext {
-			tlsConf, err := grpcurl.ClientTLSConfig(params.Insecure, params.CACert, params.Cert, params.Key)
-			if err != nil {
-				fail(err, "Failed to create TLS config")
-			}
-
-			sslKeylogFile := os.Getenv("SSLKEYLOGFILE")
-			if sslKeylogFile != "" {
-				w, err := os.OpenFile(sslKeylogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
-				if err != nil {
-					fail(err, "Could not open SSLKEYLOGFILE %s", sslKeylogFile)
-				}
-				tlsConf.KeyLogWriter = w
-			}
-
-			creds = credentials.NewTLS(tlsConf)
-
-			// can use either -servername or -authority; but not both
-			if params.ServerName != "" && params.Authority != "" {
-				if params.ServerName == params.Authority {
-					warn("Both -servername and -authority are present; prefer only -authority.")
-				} else {
-					fail(nil, "Cannot specify different values for -servername and -authority.")
-				}
-			}
-			overrideName := params.ServerName
-			if overrideName == "" {
-				overrideName = params.Authority
-			}
-
-			if overrideName != "" {
-				opts = append(opts, grpc.WithAuthority(overrideName))
-			}
-		} else if params.Authority != "" {
-			opts = append(opts, grpc.WithAuthority(params.Authority))
-		}
-
-		grpcurlUA := "trpc/" + version
-		if version == no_version {
-			grpcurlUA = "trpc/beta build"
-		}
-		if params.UserAgent != "" {
-			grpcurlUA = params.UserAgent + " " + grpcurlUA
-		}
-		opts = append(opts, grpc.WithUserAgent(grpcurlUA))
-
-		network := "tcp"
-		if isUnixSocket != nil && isUnixSocket() {
-			network = "unix"
-		}
-
-		//println("Dialing", network, params.Target)
-		cc, err := grpcurl.BlockingDial(ctx, network, params.Target, creds, opts...)
-		//cc, err := DirectDialContext(ctx, params.Target, params.PrefixPath, opts...)
-		if err != nil {
-			fail(err, "Failed to dial target host %q", params.Target)
-		}
-		return cc
+	dial := func() (*grpc.ClientConn, error) {
+		return dialTarget(ctx, params)
 	}
 	//printFormattedStatus := func(w io.Writer, stat *status.Status, formatter grpcurl.Formatter) {
 	//	formattedStatus, err := formatter(stat.Proto())
@@ -417,55 +343,66 @@ func Run(params RunParams) (*TRPCHandler, error) {
 		var err error
 		params.AddlHeaders, err = grpcurl.ExpandHeaders(params.AddlHeaders)
 		if err != nil {
-			fail(err, "Failed to expand additional headers")
+			return nil, fail(err, "Failed to expand additional headers")
 		}
 		params.RPCHeaders, err = grpcurl.ExpandHeaders(params.RPCHeaders)
 		if err != nil {
-			fail(err, "Failed to expand rpc headers")
+			return nil, fail(err, "Failed to expand rpc headers")
 		}
 		params.ReflHeaders, err = grpcurl.ExpandHeaders(params.ReflHeaders)
 		if err != nil {
-			fail(err, "Failed to expand reflection headers")
+			return nil, fail(err, "Failed to expand reflection headers")
 		}
 	}
 
+	// a fresh run or a run without a session dials its own connection and
+	// closes it when done
+	shared := params.Session != nil && !params.Fresh
 	var cc *grpc.ClientConn
-	var descSource grpcurl.DescriptorSource
 	var refClient *grpcreflect.Client
-	var fileSource grpcurl.DescriptorSource
-	if len(params.Protoset) > 0 {
+	if shared && useReflection {
 		var err error
-		fileSource, err = grpcurl.DescriptorSourceFromProtoSets(params.Protoset...)
-		if err != nil {
-			fail(err, "Failed to process proto descriptor sets.")
+		if cc, err = params.Session.conn(params, dial); err != nil {
+			return nil, err
 		}
-	} else if len(params.ProtoFiles) > 0 {
-		var err error
-		fileSource, err = grpcurl.DescriptorSourceFromProtoFiles(params.ImportPaths, params.ProtoFiles...)
+	}
+	createSource := func(srcCtx context.Context) (grpcurl.DescriptorSource, *grpcreflect.Client, error) {
+		fileSource, err := FileSource(params)
 		if err != nil {
-			fail(err, "Failed to process proto source files.")
+			return nil, nil, err
+		}
+		if !useReflection {
+			return fileSource, nil, nil
 		}
-	}
-	if reflection.val {
-		println("Dialing for reflection")
 		md := grpcurl.MetadataFromHeaders(append(params.AddlHeaders, params.ReflHeaders...))
-		refCtx := metadata.NewOutgoingContext(ctx, md)
-		cc = dial()
-		println("Continue to refClient")
-		refClient = grpcreflect.NewClient(refCtx, reflectpb.NewServerReflectionClient(RefClientConnFromConn(cc, params.PrefixPath)))
-		println("Continue to descSource")
-		reflSource := grpcurl.DescriptorSourceFromServer(ctx, refClient)
-
+		refCtx := metadata.NewOutgoingContext(srcCtx, md)
+		if cc == nil {
+			var err error
+			if cc, err = dial(); err != nil {
+				return nil, nil, err
+			}
+		}
+		client := grpcreflect.NewClient(refCtx, reflectpb.NewServerReflectionClient(RefClientConnFromConn(cc, params.PrefixPath)))
+		reflSource := grpcurl.DescriptorSourceFromServer(srcCtx, client)
 		if fileSource != nil {
-			println("Continue to merge")
-
-			descSource = compositeSource{reflSource, fileSource}
-		} else {
-			println("Continue to refSource")
-			descSource = reflSource
+			return compositeSource{reflSource, fileSource}, client, nil
+		}
+		return reflSource, client, nil
+	}
+	var descSource grpcurl.DescriptorSource
+	if shared {
+		var err error
+		if descSource, err = params.Session.source(params, useReflection, createSource); err != nil {
+			return nil, err
 		}
 	} else {
-		descSource = fileSource
+		var err error
+		if descSource, refClient, err = createSource(ctx); err != nil {
+			if cc != nil {
+				cc.Close()
+			}
+			return nil, err
+		}
 	}
 
 	// arrange for the RPCs to be cleanly shutdown
@@ -474,25 +411,39 @@ func Run(params RunParams) (*TRPCHandler, error) {
 			refClient.Reset()
 			refClient = nil
 		}
-		if cc != nil {
+		if cc != nil && !shared {
 			cc.Close()
 			//cc.(*DirectClientConn).Close()
 			cc = nil
 		}
 	}
 	defer reset()
-	exit = func(code int) {
-		// since defers aren't run by os.Exit...
-		reset()
-		os.Exit(code)
-	}
 
 	// Invoke an RPC
+	var err error
 	if cc == nil {
-		cc = dial()
+		if shared {
+			cc, err = params.Session.conn(params, dial)
+		} else {
+			cc, err = dial()
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	data, err := json.Marshal(params.Data)
+	var data []byte
+	if params.Stream != nil {
+		for _, message := range params.Stream {
+			jsonMessage, jErr := json.Marshal(message)
+			if jErr != nil {
+				return nil, fail(jErr, "Failed to encode stream message")
+			}
+			data = append(data, jsonMessage...)
+		}
+	} else {
+		data, err = json.Marshal(params.Data)
+	}
 
 	var in io.Reader
 	in = strings.NewReader(string(data))
@@ -509,7 +460,7 @@ func Run(params RunParams) (*TRPCHandler, error) {
 	//rf, formatter, err := grpcurl.RequestParserAndFormatter(grpcurl.Format("json"), descSource, in, options)
 	rf, _, err := grpcurl.RequestParserAndFormatter(grpcurl.Format("json"), descSource, in, options)
 	if err != nil {
-		fail(err, "Failed to construct request parser and formatter for json")
+		return nil, fail(err, "Failed to construct request parser and formatter for json")
 	}
 	//h := &grpcurl.DefaultEventHandler{
 	//	Out:            os.Stdout,
@@ -520,16 +471,51 @@ func Run(params RunParams) (*TRPCHandler, error) {
 	h := &TRPCHandler{
 		Descriptor: descSource,
 	}
+	if params.Lockstep {
+		h.replies = newReplies()
+		h.done = make(chan struct{})
+	}
+	replyWait := exchangeReplyWait
+	if params.MaxTime > 0 {
+		replyWait = time.Duration(params.MaxTime * float64(time.Second))
+	}
+	next := func(m proto.Message) error {
+		if sent := atomic.LoadInt32(&h.NumSent); params.Lockstep && sent > 0 {
+			// wait for a reply to the previous message itself, a reply which
+			// arrived before it was sent does not count
+			timeout := time.NewTimer(replyWait)
+			defer timeout.Stop()
+		wait:
+			for !h.replies.replied(sent) {
+				select {
+				case <-h.replies.arrived:
+				case <-h.done:
+					break wait
+				case <-ctx.Done():
+					break wait
+				case <-timeout.C:
+					return fmt.Errorf("no reply to message %d of the exchange within %v", sent, replyWait)
+				}
+			}
+		}
+		err := rf.Next(m)
+		if err == nil {
+			atomic.AddInt32(&h.NumSent, 1)
+		}
+		return err
+	}
 
 	symbol := fmt.Sprintf("%s/%s", params.ServiceName, params.MethodName)
 
-	err = grpcurl.InvokeRPC(ctx, descSource, RefClientConnFromConn(cc, params.PrefixPath) /*params.PrefixPath,*/, symbol, append(params.AddlHeaders, params.RPCHeaders...), h, rf.Next)
+	err = grpcurl.InvokeRPC(ctx, descSource, RefClientConnFromConn(cc, params.PrefixPath) /*params.PrefixPath,*/, symbol, append(params.AddlHeaders, params.RPCHeaders...), h, next)
+	if !h.sent.IsZero() {
+		h.Latency = time.Since(h.sent)
+	}
 	if err != nil {
 		if errStatus, ok := status.FromError(err); ok && params.FormatError {
 			h.Status = errStatus
 		} else {
-			fail(err, "Error invoking method %q", symbol)
-			return nil, err
+			return nil, fail(err, "Error invoking method %q", symbol)
 		}
 	}
 	reqSuffix := ""
@@ -555,6 +541,141 @@ func Run(params RunParams) (*TRPCHandler, error) {
 	return h, nil
 }
 
+// dialTarget connects to the target of the params, by TLS unless Plaintext is
+// set. It gives up after ConnectTimeout, 10 seconds when it is not set.
+func dialTarget(ctx context.Context, params RunParams) (*grpc.ClientConn, error) {
+	dialTime := 10 * time.Second
+	if params.ConnectTimeout > 0 {
+		dialTime = time.Duration(params.ConnectTimeout * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTime)
+	defer cancel()
+	var opts []grpc.DialOption
+	if params.KeepaliveTime > 0 {
+		timeout := time.Duration(params.KeepaliveTime * float64(time.Second))
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    timeout,
+			Timeout: timeout,
+		}))
+	}
+	if params.MaxMessagSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(params.MaxMessagSize)))
+	}
+	var creds credentials.TransportCredentials
+	if !params.Plaintext {
+		tlsConf, err := grpcurl.ClientTLSConfig(params.Insecure, params.CACert, params.Cert, params.Key)
+		if err != nil {
+			return nil, fail(err, "Failed to create TLS config")
+		}
+
+		sslKeylogFile := os.Getenv("SSLKEYLOGFILE")
+		if sslKeylogFile != "" {
+			w, err := os.OpenFile(sslKeylogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				return nil, fail(err, "Could not open SSLKEYLOGFILE %s", sslKeylogFile)
+			}
+			tlsConf.KeyLogWriter = w
+		}
+
+		creds = credentials.NewTLS(tlsConf)
+
+		// can use either -servername or -authority; but not both
+		if params.ServerName != "" && params.Authority != "" {
+			if params.ServerName == params.Authority {
+				warn("Both -servername and -authority are present; prefer only -authority.")
+			} else {
+				return nil, fail(nil, "Cannot specify different values for -servername and -authority.")
+			}
+		}
+		overrideName := params.ServerName
+		if overrideName == "" {
+			overrideName = params.Authority
+		}
+
+		if overrideName != "" {
+			opts = append(opts, grpc.WithAuthority(overrideName))
+		}
+	} else if params.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(params.Authority))
+	}
+
+	grpcurlUA := "trpc/" + version
+	if version == no_version {
+		grpcurlUA = "trpc/beta build"
+	}
+	if params.UserAgent != "" {
+		grpcurlUA = params.UserAgent + " " + grpcurlUA
+	}
+	opts = append(opts, grpc.WithUserAgent(grpcurlUA))
+
+	network := "tcp"
+	if isUnixSocket != nil && isUnixSocket() {
+		network = "unix"
+	}
+
+	//println("Dialing", network, params.Target)
+	cc, err := grpcurl.BlockingDial(ctx, network, params.Target, creds, opts...)
+	//cc, err := DirectDialContext(ctx, params.Target, params.PrefixPath, opts...)
+	if err != nil {
+		return nil, fail(err, "Failed to dial target host %q", params.Target)
+	}
+	return cc, nil
+}
+
+// FileSource loads the descriptors of the protosets of the params, or else of
+// their proto files, without dialing. It is nil when there are neither.
+func FileSource(params RunParams) (grpcurl.DescriptorSource, error) {
+	if len(params.Protoset) > 0 {
+		fileSource, err := grpcurl.DescriptorSourceFromProtoSets(params.Protoset...)
+		if err != nil {
+			return nil, fail(err, "Failed to process proto descriptor sets.")
+		}
+		return fileSource, nil
+	}
+	if len(params.ProtoFiles) > 0 {
+		fileSource, err := grpcurl.DescriptorSourceFromProtoFiles(params.ImportPaths, params.ProtoFiles...)
+		if err != nil {
+			return nil, fail(err, "Failed to process proto source files.")
+		}
+		return fileSource, nil
+	}
+	return nil, nil
+}
+
+// Source is the descriptor source of the params without invoking any RPC, the
+// server of the target is asked by reflection, falling back to the protosets
+// or proto files of the params for symbols, and without a target only those
+// are used. The returned func closes the connection of the reflection.
+func Source(params RunParams) (grpcurl.DescriptorSource, func(), error) {
+	fileSource, err := FileSource(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if params.Target == "" {
+		if fileSource == nil {
+			return nil, nil, fail(nil, "No target to ask by reflection and no protoset or proto files.")
+		}
+		return fileSource, func() {}, nil
+	}
+	ctx := context.Background()
+	cc, err := dialTarget(ctx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	md := grpcurl.MetadataFromHeaders(append(params.AddlHeaders, params.ReflHeaders...))
+	refCtx := metadata.NewOutgoingContext(ctx, md)
+	client := grpcreflect.NewClient(refCtx, reflectpb.NewServerReflectionClient(RefClientConnFromConn(cc, params.PrefixPath)))
+	closeSource := func() {
+		client.Reset()
+		cc.Close()
+	}
+	reflSource := grpcurl.DescriptorSourceFromServer(ctx, client)
+	if fileSource != nil {
+		return compositeSource{reflSource, fileSource}, closeSource, nil
+	}
+	return reflSource, closeSource, nil
+}
+
 func prettify(docString string) string {
 	parts := strings.Split(docString, "\n")
 
@@ -578,20 +699,14 @@ func warn(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg, args...)
 }
 
-func fail(err error, msg string, args ...interface{}) {
+// fail builds the error Run gives up with, a nil err means it was a usage
+// issue of RunParams.
+func fail(err error, msg string, args ...interface{}) error {
 	if err != nil {
 		msg += ": %v"
 		args = append(args, err)
 	}
-	fmt.Fprintf(os.Stderr, msg, args...)
-	fmt.Fprintln(os.Stderr)
-	if err != nil {
-		exit(1)
-	} else {
-		// nil error means it was CLI usage issue
-		fmt.Fprintf(os.Stderr, "Try '%s -help' for more details.\n", os.Args[0])
-		exit(2)
-	}
+	return fmt.Errorf(msg, args...)
 }
 
 //func writeProtoset(descSource grpcurl.DescriptorSource, symbols ...string) error {
@@ -2,6 +2,9 @@ package grpcrunner
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fullstorydev/grpcurl"
 	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
@@ -27,6 +30,50 @@ type TRPCHandler struct {
 	Status           *status.Status
 	NumRequests      int
 	NumResponses     int
+	// NumSent is the number of stream messages handed to the RPC so far
+	NumSent int32
+	// SentBeforeResponse holds NumSent at the time each response arrived, a
+	// reply to message N of a lockstep exchange holds N
+	SentBeforeResponse []int
+	// Latency is the wall-clock time of the RPC, from sending its headers
+	// until its status, for a stream until the stream is closed. Resolving
+	// the method, by reflection on the first call, is not part of it.
+	Latency time.Duration
+
+	sent time.Time
+	// replies is only set for a lockstep exchange
+	replies *replies
+	done    chan struct{}
+}
+
+// replies counts the responses of a lockstep exchange by NumSent at their
+// arrival, so the replies to a message are only those received after it and
+// before the next one was sent. It is shared by the copies of the handler.
+type replies struct {
+	mu      sync.Mutex
+	to      map[int32]int
+	arrived chan struct{}
+}
+
+func newReplies() *replies {
+	return &replies{to: make(map[int32]int), arrived: make(chan struct{}, 1)}
+}
+
+func (r *replies) add(sent int32) {
+	r.mu.Lock()
+	r.to[sent]++
+	r.mu.Unlock()
+	select {
+	case r.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// replied tells a response arrived after message sent and before the next one.
+func (r *replies) replied(sent int32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.to[sent] > 0
 }
 
 func (handler *TRPCHandler) OnResolveMethod(descriptor *desc.MethodDescriptor) {
@@ -38,6 +85,7 @@ func (handler *TRPCHandler) OnSendHeaders(rqHeaders metadata.MD) {
 		fmt.Println("Headers sent: \n", rqHeaders)
 	}
 	handler.NumRequests++
+	handler.sent = time.Now()
 }
 
 func (handler *TRPCHandler) OnReceiveHeaders(rsHeaders metadata.MD) {
@@ -45,11 +93,19 @@ func (handler *TRPCHandler) OnReceiveHeaders(rsHeaders metadata.MD) {
 }
 
 func (handler *TRPCHandler) OnReceiveResponse(data proto.Message) {
+	sent := atomic.LoadInt32(&handler.NumSent)
 	handler.ResponseData = append(handler.ResponseData, data)
+	handler.SentBeforeResponse = append(handler.SentBeforeResponse, int(sent))
 	handler.NumResponses++
+	if handler.replies != nil {
+		handler.replies.add(sent)
+	}
 }
 
 func (handler *TRPCHandler) OnReceiveTrailers(status *status.Status, metadata metadata.MD) {
 	handler.Trailers = metadata
 	handler.Status = status
+	if handler.done != nil {
+		close(handler.done)
+	}
 }
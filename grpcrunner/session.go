@@ -0,0 +1,137 @@
+package grpcrunner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+)
+
+// Session keeps connections and descriptor sources alive between runs, a
+// connection is shared by every run with the same target, TLS options and
+// connect timeout, so a run with its own timeout dials with it, and a
+// descriptor source by every run with the same proto imports (and the same
+// connection when reflection is used). Close it when the runs are done.
+type Session struct {
+	mu      sync.Mutex
+	conns   map[string]*pending[*grpc.ClientConn]
+	sources map[string]*pending[sessionSource]
+}
+
+type sessionSource struct {
+	source grpcurl.DescriptorSource
+	client *grpcreflect.Client
+}
+
+// pending is a connection or source of the session, ready is closed once it
+// is created or failed to be.
+type pending[T any] struct {
+	ready chan struct{}
+	value T
+	err   error
+}
+
+func (p *pending[T]) created() bool {
+	select {
+	case <-p.ready:
+		return p.err == nil
+	default:
+		return false
+	}
+}
+
+func NewSession() *Session {
+	return &Session{
+		conns:   make(map[string]*pending[*grpc.ClientConn]),
+		sources: make(map[string]*pending[sessionSource]),
+	}
+}
+
+// Close resets the reflection clients and closes the connections of the
+// session, those still being created are released by their creator.
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, source := range s.sources {
+		if source.created() && source.value.client != nil {
+			source.value.client.Reset()
+		}
+		delete(s.sources, key)
+	}
+	for key, conn := range s.conns {
+		if conn.created() {
+			conn.value.Close()
+		}
+		delete(s.conns, key)
+	}
+}
+
+func connectionKey(params RunParams) string {
+	return fmt.Sprintf("%s|%v|%v|%s|%s|%s|%s|%s|%v", params.Target, params.Plaintext, params.Insecure,
+		params.CACert, params.Cert, params.Key, params.Authority, params.ServerName, params.ConnectTimeout)
+}
+
+func importsKey(params RunParams) string {
+	return fmt.Sprintf("%s|%s|%s", strings.Join(params.Protoset, ","),
+		strings.Join(params.ImportPaths, ","), strings.Join(params.ProtoFiles, ","))
+}
+
+// share returns the entry of key, creating it on first use. It is created
+// outside the lock so entries of other keys are created meanwhile, runs
+// asking for it in the meantime wait for it. A failed entry is forgotten so
+// the next run tries again, and one created after the session was closed is
+// released.
+func share[T any](s *Session, entries map[string]*pending[T], key string, create func() (T, error), release func(T)) (T, error) {
+	s.mu.Lock()
+	entry, exists := entries[key]
+	if !exists {
+		entry = &pending[T]{ready: make(chan struct{})}
+		entries[key] = entry
+	}
+	s.mu.Unlock()
+	if exists {
+		<-entry.ready
+		return entry.value, entry.err
+	}
+
+	entry.value, entry.err = create()
+	s.mu.Lock()
+	current := entries[key] == entry
+	if current && entry.err != nil {
+		delete(entries, key)
+	}
+	s.mu.Unlock()
+	if !current && entry.err == nil {
+		release(entry.value)
+		entry.err = fmt.Errorf("the session was closed")
+	}
+	close(entry.ready)
+	return entry.value, entry.err
+}
+
+// conn returns the connection of the params, dialing it on first use.
+func (s *Session) conn(params RunParams, dial func() (*grpc.ClientConn, error)) (*grpc.ClientConn, error) {
+	return share(s, s.conns, connectionKey(params), dial, func(cc *grpc.ClientConn) { cc.Close() })
+}
+
+// source returns the descriptor source of the params, create is called on
+// first use with a context living as long as the session.
+func (s *Session) source(params RunParams, useReflection bool, create func(ctx context.Context) (grpcurl.DescriptorSource, *grpcreflect.Client, error)) (grpcurl.DescriptorSource, error) {
+	key := importsKey(params)
+	if useReflection {
+		key = connectionKey(params) + "|" + params.PrefixPath + "|" + key
+	}
+	shared, err := share(s, s.sources, key, func() (sessionSource, error) {
+		source, client, err := create(context.Background())
+		return sessionSource{source, client}, err
+	}, func(shared sessionSource) {
+		if shared.client != nil {
+			shared.client.Reset()
+		}
+	})
+	return shared.source, err
+}
@@ -0,0 +1,169 @@
+package grpcrunner
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+func runInSession(t *testing.T, session *Session, target string, fresh bool) {
+	t.Helper()
+	_, err := Run(RunParams{
+		Target:      target,
+		Plaintext:   true,
+		ServiceName: "grpc.testing.TestService",
+		MethodName:  "FullDuplexCall",
+		Stream:      []map[string]interface{}{{}},
+		Session:     session,
+		Fresh:       fresh,
+		FormatError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sessionConns are the connections created by the session.
+func sessionConns(session *Session) []*grpc.ClientConn {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	conns := make([]*grpc.ClientConn, 0)
+	for _, conn := range session.conns {
+		if conn.created() {
+			conns = append(conns, conn.value)
+		}
+	}
+	return conns
+}
+
+func TestSessionConnections(t *testing.T) {
+	target := serveTest(t, &exchangeServer{replies: []int{1}})
+	other := serveTest(t, &exchangeServer{replies: []int{1}})
+	session := NewSession()
+
+	runInSession(t, session, target, true)
+	if conns := sessionConns(session); len(conns) != 0 {
+		t.Fatalf("a fresh run leaves %d connection(s) in the session", len(conns))
+	}
+
+	runInSession(t, session, target, false)
+	conns := sessionConns(session)
+	if len(conns) != 1 {
+		t.Fatalf("the session has %d connection(s), want 1", len(conns))
+	}
+	runInSession(t, session, target, false)
+	if again := sessionConns(session); len(again) != 1 || again[0] != conns[0] {
+		t.Errorf("a second run to the same target does not reuse its connection")
+	}
+	runInSession(t, session, target, true)
+	if again := sessionConns(session); len(again) != 1 {
+		t.Errorf("a fresh run adds a connection to the session")
+	}
+	runInSession(t, session, other, false)
+	conns = sessionConns(session)
+	if len(conns) != 2 {
+		t.Fatalf("the session has %d connection(s) to 2 targets", len(conns))
+	}
+
+	session.Close()
+	for _, cc := range conns {
+		if state := cc.GetState(); state != connectivity.Shutdown {
+			t.Errorf("connection to %s is %v after Close", cc.Target(), state)
+		}
+	}
+	if left := sessionConns(session); len(left) != 0 {
+		t.Errorf("the session keeps %d connection(s) after Close", len(left))
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if len(session.sources) != 0 {
+		t.Errorf("the session keeps %d source(s) after Close", len(session.sources))
+	}
+}
+
+func TestSessionShare(t *testing.T) {
+	session := NewSession()
+	entries := make(map[string]*pending[int])
+	release := func(int) {}
+
+	// a slow entry does not hold back another one
+	started, unblock := make(chan struct{}), make(chan struct{})
+	slow := make(chan int)
+	go func() {
+		value, _ := share(session, entries, "slow", func() (int, error) {
+			close(started)
+			<-unblock
+			return 1, nil
+		}, release)
+		slow <- value
+	}()
+	<-started
+	done := make(chan struct{})
+	go func() {
+		share(session, entries, "fast", func() (int, error) { return 2, nil }, release)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating an entry waits for the creation of another one")
+	}
+
+	// runs asking for an entry being created wait for it, which is created once
+	created := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := share(session, entries, "slow", func() (int, error) {
+				created++
+				return 3, nil
+			}, release)
+			if err != nil || value != 1 {
+				t.Errorf("waiting run got %d, %v, want 1", value, err)
+			}
+		}()
+	}
+	close(unblock)
+	if value := <-slow; value != 1 {
+		t.Errorf("slow entry is %d, want 1", value)
+	}
+	wg.Wait()
+	if created != 0 {
+		t.Errorf("the entry is created %d more time(s)", created)
+	}
+
+	// a failed entry is tried again
+	failed := errors.New("unreachable")
+	if _, err := share(session, entries, "down", func() (int, error) { return 0, failed }, release); err != failed {
+		t.Errorf("failed entry: error %v", err)
+	}
+	if value, err := share(session, entries, "down", func() (int, error) { return 4, nil }, release); err != nil || value != 4 {
+		t.Errorf("entry after a failure is %d, %v, want 4", value, err)
+	}
+
+	// an entry created while the session is closed is released
+	released := 0
+	creating := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		<-creating
+		session.mu.Lock()
+		delete(entries, "closing")
+		session.mu.Unlock()
+		close(closed)
+	}()
+	_, err := share(session, entries, "closing", func() (int, error) {
+		close(creating)
+		<-closed
+		return 5, nil
+	}, func(int) { released++ })
+	if err == nil || released != 1 {
+		t.Errorf("entry created while closing: error %v, released %d time(s)", err, released)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// includeEntries replaces every include of entries with the entries of the
+// included file, recursively. The path of an include is relative to the file
+// declaring it, a file is included once and including a file which is being
+// included is a cycle. including is the chain of files up to entries and
+// included every file seen so far, both by their absolute path.
+func (run *testRun) includeEntries(entries []*Entry, including []string, included map[string]bool) []*Entry {
+	lines := run.mainEntery.Lines
+	expanded := make([]*Entry, 0, len(entries))
+	for _, entery := range entries {
+		if entery.Include == "" {
+			expanded = append(expanded, entery)
+			continue
+		}
+		path, _ := strconv.Unquote(entery.Include)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(entery.Pos.Filename), path)
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			syntaxError(lines, entery.Pos, 0, "Invalid include path %s: %v", path, err)
+		}
+		for i, file := range including {
+			if file == absPath {
+				cycle := append(append([]string{}, including[i:]...), absPath)
+				syntaxError(lines, entery.Pos, 0, "Include cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if included[absPath] {
+			continue
+		}
+		included[absPath] = true
+
+		trpc := run.parseInclude(entery, path)
+		entries := rebaseImports(trpc.Entries, filepath.Dir(path))
+		expanded = append(expanded, run.includeEntries(entries, append(including, absPath), included)...)
+	}
+	return expanded
+}
+
+// rebaseImports makes the import paths and protosets of an included file
+// relative to its directory, as its includes are. A proto file is relative to
+// the import paths, the directory is one of them when the file is found there.
+func rebaseImports(entries []*Entry, dir string) []*Entry {
+	rebase := func(quoted string) string {
+		path, _ := strconv.Unquote(quoted)
+		if filepath.IsAbs(path) {
+			return quoted
+		}
+		return strconv.Quote(filepath.Join(dir, path))
+	}
+	rebased := make([]*Entry, 0, len(entries))
+	dirImported := false
+	for _, entery := range entries {
+		if entery.ImportPath != "" {
+			entery.ImportPath = rebase(entery.ImportPath)
+		}
+		if entery.ImportProtoSet != "" {
+			entery.ImportProtoSet = rebase(entery.ImportProtoSet)
+		}
+		if entery.ImportProto != "" && !dirImported {
+			path, _ := strconv.Unquote(entery.ImportProto)
+			if _, err := os.Stat(filepath.Join(dir, path)); err == nil && !filepath.IsAbs(path) {
+				rebased = append(rebased, &Entry{Pos: entery.Pos, ImportPath: strconv.Quote(dir)})
+				dirImported = true
+			}
+		}
+		rebased = append(rebased, entery)
+	}
+	return rebased
+}
+
+// parseInclude parses an included file, which may declare anything but a test.
+func (run *testRun) parseInclude(entery *Entry, path string) *Trpc {
+	lines := run.mainEntery.Lines
+	fileLines, err := readLines(path)
+	if err != nil {
+		syntaxError(lines, entery.Pos, 0, "Can not include %s: %v", path, err)
+	}
+	sourceLines[path] = &fileLines
+	file, err := os.Open(path)
+	if err != nil {
+		syntaxError(lines, entery.Pos, 0, "Can not include %s: %v", path, err)
+	}
+	defer file.Close()
+	trpc := &Trpc{}
+	if err := parser.Parse(path, file, trpc); err != nil {
+		syntaxError(lines, entery.Pos, 0, "Can not include %s: %v", path, err)
+	}
+	for _, included := range trpc.Entries {
+		if included.TestName != "" {
+			syntaxError(lines, included.Pos, 0, "Included file %s can not declare a test", path)
+		}
+	}
+	return trpc
+}
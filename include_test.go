@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"trpc/grpcrunner"
+)
+
+func TestIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.trpc": testHeader + `
+include "common/b.trpc"
+include "common/c.trpc"
+let A = "a"
+`,
+		"common/b.trpc": `
+include "d.trpc"
+let B = D
+`,
+		"common/c.trpc": `
+include "../shared/d.trpc"
+include "d.trpc"
+let C = D
+`,
+		"common/d.trpc": `let D = "common"`,
+		"shared/d.trpc": `let SHARED = "shared"`,
+	})
+	run := loadFile(t, filepath.Join(dir, "a.trpc"), "")
+	// the diamond a -> b -> d, a -> c -> d merges d once, where b includes it
+	want := Variables{"D": "common", "B": "common", "SHARED": "shared", "C": "common", "A": "a"}
+	if !reflect.DeepEqual(run.variables, want) {
+		t.Errorf("variables %v, want %v", run.variables, want)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "direct cycle",
+			files: map[string]string{"b.trpc": `include "b.trpc"`},
+			err:   "Include cycle: %[1]s/b.trpc -> %[1]s/b.trpc",
+		},
+		{
+			name:  "indirect cycle",
+			files: map[string]string{"b.trpc": `include "sub/c.trpc"`, "sub/c.trpc": `include "../b.trpc"`},
+			err:   "Include cycle: %[1]s/b.trpc -> %[1]s/sub/c.trpc -> %[1]s/b.trpc",
+		},
+		{
+			name:  "cycle through the test file",
+			files: map[string]string{"b.trpc": `include "a.trpc"`},
+			err:   "Include cycle: %[1]s/a.trpc -> %[1]s/b.trpc -> %[1]s/a.trpc",
+		},
+		{
+			name:  "missing file",
+			files: map[string]string{"b.trpc": `include "c.trpc"`},
+			err:   "Can not include %[1]s/c.trpc",
+		},
+		{
+			name:  "test in an included file",
+			files: map[string]string{"b.trpc": testHeader},
+			err:   "Included file %[1]s/b.trpc can not declare a test",
+		},
+	} {
+		test.files["a.trpc"] = testHeader + "\ninclude \"b.trpc\"\n"
+		dir := writeFiles(t, test.files)
+		err := recoverError(t, func() { loadFile(t, filepath.Join(dir, "a.trpc"), "") })
+		want := fmt.Sprintf(test.err, dir)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", test.name, err, want)
+		}
+	}
+}
+
+func TestIncludeImports(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.trpc": testHeader + `
+include "common/shared.trpc"
+`,
+		"common/shared.trpc": `
+importpath "protos"
+import protofile "users.proto"
+import protofile "local.proto"
+import protoset "sets/all.protoset"
+`,
+		"common/protos/users.proto": "syntax = \"proto3\";\npackage users;\nmessage U {}\nservice Users { rpc Get(U) returns (U); }\n",
+		"common/local.proto":        "syntax = \"proto3\";\npackage local;\nmessage L {}\nservice Local { rpc Get(L) returns (L); }\n",
+	})
+	run := loadFile(t, filepath.Join(dir, "a.trpc"), "")
+	common := filepath.Join(dir, "common")
+	for _, test := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"import paths", run.protoImportPaths, []string{filepath.Join(common, "protos"), common}},
+		{"proto files", run.protoFiles, []string{"users.proto", "local.proto"}},
+		{"protosets", run.protoSets, []string{filepath.Join(common, "sets", "all.protoset")}},
+	} {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	// the proto files resolve wherever trpc runs from
+	source, err := grpcrunner.FileSource(grpcrunner.RunParams{ImportPaths: run.protoImportPaths, ProtoFiles: run.protoFiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, symbol := range []string{"users.Users", "local.Local"} {
+		if _, err := source.FindSymbol(symbol); err != nil {
+			t.Errorf("%s: %v", symbol, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"trpc/grpcrunner"
+
+	"github.com/fatih/color"
+)
+
+type listCmd struct {
+	File     string `required existing file arg help:"TRPC(Test RPC) file whose services are listed."`
+	Service  string `arg optional help:"Service to list the methods of, package.Service."`
+	Endpoint string `help:"Endpoint of the file to ask by reflection, otherwise the imports of the file are listed or every endpoint is asked when it has none."`
+	Profile  string `help:"Environment profile which overrides variables and endpoints, as for run."`
+}
+
+type describeCmd struct {
+	File        string   `required existing file arg help:"TRPC(Test RPC) file whose services are described."`
+	Symbols     []string `arg optional help:"Services, methods, messages or enums to describe, every service when none is given."`
+	Endpoint    string   `help:"Endpoint of the file to ask by reflection, otherwise the imports of the file are described or every endpoint is asked when it has none."`
+	Profile     string   `help:"Environment profile which overrides variables and endpoints, as for run."`
+	MsgTemplate bool     `help:"Follow every message by a template of it in JSON."`
+}
+
+// descriptorSource is where the descriptors of a file are asked from.
+type descriptorSource struct {
+	// name is the endpoint, empty for the imports of the file
+	name   string
+	params grpcrunner.RunParams
+}
+
+func (source descriptorSource) String() string {
+	if source.name == "" {
+		return "imports"
+	}
+	return fmt.Sprintf("endpoint %s %s", source.name, source.params.Target)
+}
+
+func (cmd *listCmd) Run() error {
+	sources, err := descriptorSources(cmd.File, cmd.Profile, cmd.Endpoint)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, source := range sources {
+		if len(sources) > 1 {
+			color.Cyan("%s:", source)
+		}
+		names, err := grpcrunner.List(source.params, cmd.Service)
+		if err != nil {
+			color.Red("%v", err)
+			failed++
+			continue
+		}
+		if len(names) == 0 && cmd.Service == "" {
+			fmt.Println("(No services)")
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+	if failed > 0 {
+		return trpcError{ExitCode: 1}
+	}
+	return nil
+}
+
+func (cmd *describeCmd) Run() error {
+	sources, err := descriptorSources(cmd.File, cmd.Profile, cmd.Endpoint)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, source := range sources {
+		if len(sources) > 1 {
+			color.Cyan("%s:", source)
+		}
+		source.params.MsgTemplate = cmd.MsgTemplate
+		if err := grpcrunner.Describe(os.Stdout, source.params, cmd.Symbols...); err != nil {
+			color.Red("%v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return trpcError{ExitCode: 1}
+	}
+	return nil
+}
+
+// descriptorSources loads the file as run does and tells where its services
+// are described: the named endpoint by reflection, otherwise the imports of the
+// file as run takes them instead of reflection or, without imports, every
+// endpoint of the file by reflection.
+func descriptorSources(file string, profile string, endpoint string) (sources []descriptorSource, err error) {
+	trpc, err := parseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(trpc.Entries) == 0 {
+		return nil, trpcError{ExitCode: 2, Msg: fmt.Sprintf("%s declares nothing", file)}
+	}
+	run := newTestRun(trpc.Entries[0])
+	defer func() {
+		if loadErr := recoveredError(recover()); loadErr != nil {
+			err = loadErr
+		}
+	}()
+	run.load(trpc, profile)
+
+	if endpoint != "" {
+		endPoint, ok := run.namedEndpointes[endpoint]
+		if !ok {
+			return nil, trpcError{ExitCode: 2, Msg: fmt.Sprintf("Endpoint %s not found in %s", endpoint, file)}
+		}
+		return []descriptorSource{{name: endpoint, params: run.endpointParams(endPoint)}}, nil
+	}
+	if len(run.protoFiles) > 0 || len(run.protoSets) > 0 {
+		params := run.endpointParams(Endpoint{})
+		params.Target = ""
+		return []descriptorSource{{params: params}}, nil
+	}
+	names := make([]string, 0, len(run.namedEndpointes))
+	for name := range run.namedEndpointes {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, trpcError{ExitCode: 2, Msg: fmt.Sprintf("%s has no endpoint to ask by reflection and imports no protofile or protoset", file)}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sources = append(sources, descriptorSource{name: name, params: run.endpointParams(run.namedEndpointes[name])})
+	}
+	return sources, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const listProto = `syntax = "proto3";
+package shop;
+
+message Item {
+  string id = 1;
+}
+
+service Shop {
+  rpc Get(Item) returns (Item);
+}
+
+service Admin {
+  rpc Reset(Item) returns (Item);
+}
+`
+
+func TestDescriptorSources(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"shop.proto": listProto})
+	endpoints := `
+endpoint b "127.0.0.1" port 50052
+endpoint a "127.0.0.1" port 50051
+profile "staging" {
+  endpoint a "staging.local" port 8443
+}
+`
+	imports := fmt.Sprintf("importpath %q\nimport protofile \"shop.proto\"\n", dir)
+	for _, test := range []struct {
+		name     string
+		source   string
+		profile  string
+		endpoint string
+		sources  []string
+		err      string
+	}{
+		{"named endpoint", imports + endpoints, "", "a", []string{"endpoint a 127.0.0.1:50051"}, ""},
+		{"named endpoint of the profile", imports + endpoints, "staging", "a", []string{"endpoint a staging.local:8443"}, ""},
+		{"imports", imports + endpoints, "", "", []string{"imports"}, ""},
+		{"all endpoints", endpoints, "", "", []string{"endpoint a 127.0.0.1:50051", "endpoint b 127.0.0.1:50052"}, ""},
+		{"unknown endpoint", endpoints, "", "c", nil, "Endpoint c not found in"},
+		{"nothing to ask", "", "", "", nil, "has no endpoint to ask by reflection and imports no protofile or protoset"},
+		{"invalid file", "let A = B", "", "", nil, "Unknown variable B"},
+	} {
+		path := filepath.Join(writeFiles(t, map[string]string{"a.trpc": testHeader + "\n" + test.source}), "a.trpc")
+		var sources []descriptorSource
+		var err error
+		captureOutput(t, func() { sources, err = descriptorSources(path, test.profile, test.endpoint) })
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := make([]string, len(sources))
+		for i, source := range sources {
+			got[i] = source.String()
+			// the imports are used along reflection
+			if test.source != endpoints && len(source.params.ProtoFiles) != 1 {
+				t.Errorf("%s: %s has proto files %v", test.name, source, source.params.ProtoFiles)
+			}
+		}
+		if strings.Join(got, "; ") != strings.Join(test.sources, "; ") {
+			t.Errorf("%s: sources %v, want %v", test.name, got, test.sources)
+		}
+	}
+}
+
+func TestListAndDescribeImports(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"shop.proto": listProto})
+	path := filepath.Join(dir, "a.trpc")
+	source := fmt.Sprintf("%s\nimportpath %q\nimport protofile \"shop.proto\"\nendpoint a \"127.0.0.1\" port 50051\n", testHeader, dir)
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		cmd  interface{ Run() error }
+		want string
+	}{
+		{"list", &listCmd{File: path}, "shop.Admin\nshop.Shop\n"},
+		{"list methods", &listCmd{File: path, Service: "shop.Shop"}, "shop.Shop.Get\n"},
+		{"describe", &describeCmd{File: path, Symbols: []string{"shop.Admin"}}, "shop.Admin is a service:\nservice Admin {\n  rpc Reset ( .shop.Item ) returns ( .shop.Item );\n}\n"},
+	} {
+		var err error
+		printed := captureOutput(t, func() { err = test.cmd.Run() })
+		if err != nil || printed != test.want {
+			t.Errorf("%s: error %v, output\n%s\nwant:\n%s", test.name, err, printed, test.want)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/alecthomas/repr"
@@ -33,48 +36,114 @@ type Entry struct {
 	ImportPath     string    `| "importpath" @String`
 	ImportProto    string    `| "import" "protofile" @String`
 	ImportProtoSet string    `| "import" "protoset" @String`
+	Include        string    `| "include" @String`
+	Let            *Let      `| @@`
+	Profile        *Profile  `| @@`
 	Endpoint       *Endpoint `| @@`
 	Invoke         *Invoke   `| @@`
+	Setup          *Phase    `| "setup" @@`
+	Teardown       *Phase    `| "teardown" @@`
 
 	Lines    *[]string
 	Warnings int
 	Ignores  int
 }
 
+// Phase is a setup or teardown block of invokes, setup runs before the other
+// invokes of the file and teardown after all of them, whatever their outcome.
+type Phase struct {
+	Pos lexer.Position
+
+	Invokes []*Invoke `"{" ( @@ ";"* )* "}"`
+}
+
 type Endpoint struct {
 	Pos lexer.Position
 
-	Name     string `"endpoint" @Ident`
-	Tls      bool   `(@"tls")?`
-	IPDomain string `@String`
+	Name string `"endpoint" @Ident`
+	Tls  bool   `(@"tls")?`
+	Host *Value `@@`
 	//IPDomain             string `( @(Ident ( "." Ident )*) | @([0-9]{1,3} ( "." [0-9]{1,3})*) )`
-	Port                 int    `"port" @Int`
+	PortValue            *Value `"port" @@`
+	CACertValue          *Value `( "cacert" @@`
+	CertValue            *Value `| "cert" @@`
+	KeyValue             *Value `  "key" @@`
+	Insecure             bool   `| @"insecureSkipVerify"`
+	AuthorityValue       *Value `| "authority" @@`
+	ServerNameValue      *Value `| "serverName" @@ )*`
 	PerfixPath           string `("path" @("/" Ident ( "/" Ident )*))?`
 	ReflectionPerfixPath string `("reflectPath" @("/" Ident ( "/" Ident )*))?`
 	IgnoreTrailers       bool   `(@"ignTrailer")?`
+	// These ones are runtime extracted values
+	IPDomain   string
+	Port       int
+	CACert     string
+	Cert       string
+	Key        string
+	Authority  string
+	ServerName string
+}
+
+// Let declares a variable of the file, "let NAME = <Value>", other values use
+// it by its name or inside strings by "${NAME}".
+type Let struct {
+	Pos lexer.Position
+
+	Name  string `"let" @Ident "="`
+	Value Value  `@@`
 }
 
+type Variables = map[string]any
+
 type Invoke struct {
 	Pos lexer.Position
 
-	Name          string    `"invoke" @Ident`
-	EndPoint      string    `@Ident`
-	Service       string    `@Ident @( "." Ident )*`
-	RPC           string    `@Ident`
-	Goal          string    `( "goal" @String )?`
-	Headers       []*Header `("headers" "{" @@* "}")?`
-	Data          []*Data   `("data" "{" @@* "}")?`
-	SourceExpects []*Expect `("expects" "{" @@* "}")?`
+	Name          string      `"invoke" @Ident`
+	EndPoint      string      `@Ident`
+	Service       string      `@Ident @( "." Ident )*`
+	RPC           string      `@Ident`
+	Goal          string      `( "goal" @String )?`
+	Fresh         bool        `(@"fresh")?`
+	Timeout       *Value      `( "timeout" @@`
+	Deadline      *Value      `| "deadline" @@ )*`
+	Cases         *Cases      `( @@ )?`
+	Headers       []*Header   `("headers" "{" @@* "}")?`
+	Data          []*Data     `("data" "{" @@* "}")?`
+	Stream        *Array      `("stream" @@)?`
+	Exchange      []*Exchange `("exchange" "{" @@* "}")?`
+	Retry         *Retry      `( @@ )?`
+	SourceExpects []*Expect   `("expects" "{" @@* "}")?`
 	// These ones are runtime extracted values
 	Entry              *Entry
 	ContaineReferences bool
+	Variables          Variables
 	RequestData        map[string]any
+	RequestStream      []map[string]any
 	RequestHeaders     []string
 	Expects            []Expect
 	Response           *map[string]any
+	Responses          []map[string]any
 	ResponseJson       string
-	ResponseHeaders    []string
+	ResponsesJson      string
+	ResponseHeaders    map[string]any
+	ResponseTrailers   map[string]any
+	Details            []any
+	DetailsJson        string
 	Conditions         []InvokeCondition
+	TimeoutValue       time.Duration
+	DeadlineValue      time.Duration
+	// quiet keeps failures of the attempts of a retry from being printed
+	quiet bool
+}
+
+// Exchange is a step of a bidirectional stream, the message is sent only after
+// the reply of the previous step is received and its expects apply to the
+// reply of this message.
+type Exchange struct {
+	Pos lexer.Position
+
+	Send    *Map      `"send" @@`
+	Expects []*Expect `("expects" "{" @@* "}")?`
 }
 
 type NamedInvokes = map[string]*Invoke
@@ -139,61 +208,37 @@ type Value struct {
 	Pos lexer.Position
 
 	String    *string   `  @String`
+	Env       *Env      `| @@`
+	Bool      *Boolean  `| @("true" | "false")`
+	Now       bool      `| @"now" "(" ")"`
 	Reference *PathExpr `| @@`
 	RawString *string   `| @Ident`
 	Float     *float64  `| @Float`
 	Int       *int64    `| @Int`
-	Bool      *bool     `| (@"true" | "false")`
 	Map       *Map      `| @@`
 	Array     *Array    `| @@`
 }
 
-type PathExpr struct {
-	Parts []Part `@@ ( "." @@ )*`
-}
-
-func (p PathExpr) String() string {
-	parts := []string{}
-	for _, part := range p.Parts {
-		parts = append(parts, part.String())
-	}
-	return strings.Join(parts, ".")
-}
+type Boolean bool
 
-type Part struct {
-	Obj string `@Ident`
-	Acc []Acc  `("[" @@ "]")*`
-	//Param []Value `| @("(" @@ ")")`
-}
-
-func (p Part) String() string {
-	str := p.Obj
-	if len(p.Acc) > 0 {
-		for _, acc := range p.Acc {
-			str = str + fmt.Sprintf("[%s]", acc.String())
-		}
-	}
-	return str
+func (b *Boolean) Capture(values []string) error {
+	*b = values[0] == "true"
+	return nil
 }
 
-type Acc struct {
-	StrIndex *string `@(String|Char|RawString)`
-	IntIndex *int    `| @Int`
-}
+// Env looks up an environment variable, env("NAME") or env("NAME", default)
+type Env struct {
+	Pos lexer.Position
 
-func (a Acc) String() string {
-	if a.StrIndex != nil {
-		return *a.StrIndex
-	} else {
-		return fmt.Sprintf("%d", *a.IntIndex)
-	}
+	Name    string `"env" "(" @String`
+	Default *Value `( "," @@ )? ")"`
 }
 
 type Header struct {
 	Pos lexer.Position
 
 	Key   string `(@String ":"`
-	Value string ` @String )(",")? `
+	Value Value  ` @@ )(",")? `
 }
 
 type Array struct {
@@ -218,9 +263,10 @@ type MapEntry struct {
 type Expect struct {
 	Pos lexer.Position
 
-	Path     *PathExpr `@@`
-	Function *Function `@@`
-	OnFail   *string   `( "onFail" @("Panic"|"Warn"|"Ignore") )?`
+	Quantifier *string   `( @("every"|"some") )?`
+	Path       *PathExpr `@@`
+	Function   *Function `@@`
+	OnFail     *string   `( "onFail" @("Panic"|"Warn"|"Ignore") )?`
 	//Code      []string
 	Invoke *Invoke
 }
@@ -228,34 +274,102 @@ type Expect struct {
 type Function struct {
 	Pos lexer.Position
 
-	Name string `( @Ident`
-	Arg  Value  ` "(" (@@)? ")" )`
+	Name string   `( @Ident`
+	Args []*Value ` "(" ( @@ ( "," @@ )* )? ")" )`
 }
 
 var (
 	parser = participle.MustBuild(&Trpc{}, participle.UseLookahead(2))
-	cli    struct {
-		Files []string `required existing file arg help:"TRPC(Test RPC) file(s).\n trpc file or trpc file1 file2 or trpc *.trpc"`
+	// pathParser parses the paths of "${...}" in strings
+	pathParser = participle.MustBuild(&PathExpr{}, participle.UseLookahead(2))
+	cli        struct {
+		Run      runCmd      `cmd help:"Run the tests of TRPC file(s), the command when none is given."`
+		Check    checkCmd    `cmd help:"Check TRPC file(s) against their imported proto files or protosets without calling any RPC."`
+		Fmt      fmtCmd      `cmd help:"Format TRPC file(s) in the canonical form."`
+		Gen      genCmd      `cmd help:"Generate a TRPC file invoking services or methods, from reflection or proto files."`
+		List     listCmd     `cmd help:"List the services, or the methods of a service, of the endpoints or imports of a TRPC file."`
+		Describe describeCmd `cmd help:"Describe services, methods and messages of the endpoints or imports of a TRPC file."`
 	}
 )
 
-func (value Value) value(lines *[]string, namedInvokes *NamedInvokes, withReference bool) (interface{}, bool) {
+type runCmd struct {
+	Files    []string          `required existing file arg help:"TRPC(Test RPC) file(s).\n trpc file or trpc file1 file2 or trpc *.trpc"`
+	Report   map[string]string `help:"Write test report(s), --report junit=report.xml or --report json=report.json" placeholder:"FORMAT=PATH"`
+	Profile  string            `help:"Environment profile which overrides variables and endpoints, from profile blocks or trpc.env.json next to the file."`
+	Parallel int               `default:"1" help:"Number of invokes which may run at once, an invoke still waits for the invokes it references."`
+}
+
+// interpolationRegex matches "${...}", or "${..." left unclosed.
+var interpolationRegex = regexp.MustCompile(`\$\{([^}]*)(\})?`)
+
+// interpolate replaces every "${path}" of the string by the value of the
+// path, a variable such as "${USER.name}" or a reference to an invoke such as
+// "${login.response.token}". A reference is left as it is until invokes are
+// referenced, the bool tells there is one.
+func interpolate(lines *[]string, pos lexer.Position, str string, namedInvokes *NamedInvokes, variables Variables, withReference bool) (string, bool) {
+	haveReference := false
+	interpolated := interpolationRegex.ReplaceAllStringFunc(str, func(match string) string {
+		groups := interpolationRegex.FindStringSubmatch(match)
+		if groups[2] == "" {
+			syntaxError(lines, pos, 0, "Unclosed ${ in %s", str)
+		}
+		expr := &PathExpr{}
+		if err := pathParser.ParseString(pos.Filename, strings.TrimSpace(groups[1]), expr); err != nil {
+			syntaxError(lines, pos, 0, "Invalid path %s in %s: %v", match, str, err)
+		}
+		name := expr.Parts[0].Obj
+		if _, ok := variables[name]; !ok && (namedInvokes == nil || (*namedInvokes)[name] == nil) {
+			syntaxError(lines, pos, 0, "Unknown variable %s in %s", name, str)
+		}
+		value, isReference := Value{Pos: pos, Reference: expr}.value(lines, namedInvokes, variables, withReference)
+		if isReference {
+			haveReference = true
+			if !withReference {
+				return match
+			}
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			jb, _ := json.Marshal(value)
+			return string(jb)
+		}
+		return fmt.Sprint(value)
+	})
+	return interpolated, haveReference
+}
+
+func (value Value) value(lines *[]string, namedInvokes *NamedInvokes, variables Variables, withReference bool) (interface{}, bool) {
 	var haveReference bool = false
 	if value.Map != nil {
 		result := make(map[string]interface{})
 		for _, entry := range value.Map.Entries {
-			result[entry.Key], haveReference = entry.Value.value(lines, namedInvokes, withReference)
+			var entryHaveReference bool
+			result[entry.Key], entryHaveReference = entry.Value.value(lines, namedInvokes, variables, withReference)
+			haveReference = haveReference || entryHaveReference
 		}
 		return result, haveReference
 	} else if value.Array != nil {
 		result := make([]interface{}, len(value.Array.Elements))
 		for i, element := range value.Array.Elements {
-			result[i], haveReference = element.value(lines, namedInvokes, withReference)
+			var elementHaveReference bool
+			result[i], elementHaveReference = element.value(lines, namedInvokes, variables, withReference)
+			haveReference = haveReference || elementHaveReference
 		}
 		return result, haveReference
 	} else if value.String != nil {
 		val, _ := strconv.Unquote(*value.String)
-		return val, haveReference
+		return interpolate(lines, value.Pos, val, namedInvokes, variables, withReference)
+	} else if value.Now {
+		return time.Now().UTC(), false
+	} else if value.Env != nil {
+		name, _ := strconv.Unquote(value.Env.Name)
+		if env, ok := os.LookupEnv(name); ok {
+			return env, false
+		}
+		if value.Env.Default == nil {
+			syntaxError(lines, value.Env.Pos, 0, "Environment variable %s is not set and has no default", name)
+		}
+		return value.Env.Default.value(lines, namedInvokes, variables, withReference)
 	} else if value.RawString != nil {
 		return *value.RawString, false
 	} else if value.Float != nil {
@@ -263,10 +377,24 @@ func (value Value) value(lines *[]string, namedInvokes *NamedInvokes, withRefere
 	} else if value.Int != nil {
 		return *value.Int, haveReference
 	} else if value.Bool != nil {
-		return *value.Bool, haveReference
+		return bool(*value.Bool), haveReference
 	} else if value.Reference != nil {
 		parts := value.Reference.Parts
-		if parts[1].Obj != "response" && parts[1].Obj != "data" {
+		eval := func(filterValue *Value) any {
+			val, _ := filterValue.value(lines, namedInvokes, variables, withReference)
+			return val
+		}
+		if variable, ok := variables[parts[0].Obj]; ok {
+			val, err := resolvePath(map[string]any{parts[0].Obj: variable}, parts, eval)
+			if err != nil {
+				syntaxError(lines, value.Pos, 0, "Invalid variable reference %s: %v", value.Reference, err)
+			}
+			return val, false
+		}
+		if len(parts) == 1 && len(parts[0].Acc) == 0 {
+			syntaxError(lines, value.Pos, 0, "Unknown variable %s", value.Reference)
+		}
+		if len(parts) < 2 || !referenceRoots[parts[1].Obj] {
 			syntaxError(lines, value.Pos, 0, "Invalid reference: %s", value.Reference)
 		}
 		if invoke, ok := (*namedInvokes)[parts[0].Obj]; ok {
@@ -275,31 +403,11 @@ func (value Value) value(lines *[]string, namedInvokes *NamedInvokes, withRefere
 					syntaxError(lines, value.Pos, 0, "Reference error %v, invoke must be called before use!", value.Reference)
 					return nil, false
 				}
-				var reference map[string]any
-				if parts[1].Obj == "response" {
-					reference = (*invoke.Response)
-				} else {
-					reference = invoke.RequestData
-				}
-				parts = parts[2:]
-				for {
-					if len(parts) == 1 {
-						//fmt.Printf("ref 1 part %s : %v\n", parts[0].Obj, response[parts[0].Obj])
-						return reference[parts[0].Obj], true
-					}
-					//fmt.Printf("ref 2 %v -> %v\n", parts[0].Obj, parts[0].Acc)
-					if len(parts[0].Acc) == 0 {
-						reference = reference[parts[0].Obj].(map[string]interface{})
-					} else {
-						if parts[0].Acc[0].StrIndex != nil {
-							reference = reference[parts[0].Obj].(map[string]interface{})[*parts[0].Acc[0].StrIndex].(map[string]interface{})
-						} else {
-							reference = reference[parts[0].Obj].(map[string]interface{})[strconv.Itoa(*parts[0].Acc[0].IntIndex)].(map[string]interface{})
-						}
-					}
-					parts = parts[1:]
+				reference, err := resolvePath(invoke.referenceRoot(), parts[1:], eval)
+				if err != nil {
+					syntaxError(lines, value.Pos, 0, "Reference %v does not resolve: %v", value.Reference, err)
 				}
-
+				return reference, true
 			}
 		} else {
 			syntaxError(lines, value.Pos, 0, "Reference not found %v", value.Reference)
@@ -311,33 +419,134 @@ func (value Value) value(lines *[]string, namedInvokes *NamedInvokes, withRefere
 }
 
 func (invoke *Invoke) Parse(lines *[]string, namedInvokes *NamedInvokes, withReference bool, parseHeaders bool) error {
-	if invoke.Data == nil {
-		return nil
-	}
-	invoke.RequestData = make(map[string]interface{})
 	haveReference := false
-	for _, data := range invoke.Data {
-		var valueHaveReference bool
-		invoke.RequestData[data.Key], valueHaveReference = data.Value.value(lines, namedInvokes, withReference)
-		haveReference = haveReference || valueHaveReference
+	if invoke.Data != nil {
+		invoke.RequestData = make(map[string]interface{})
+		for _, data := range invoke.Data {
+			var valueHaveReference bool
+			invoke.RequestData[data.Key], valueHaveReference = data.Value.value(lines, namedInvokes, invoke.Variables, withReference)
+			haveReference = haveReference || valueHaveReference
+		}
 	}
-	invoke.ContaineReferences = haveReference
-	//parsing Headers must run once on the parsing file
-	if parseHeaders && invoke.Headers != nil {
+	if invoke.Stream != nil || invoke.Exchange != nil {
+		messages := make([]*Value, 0)
+		if invoke.Stream != nil {
+			messages = invoke.Stream.Elements
+		}
+		for _, step := range invoke.Exchange {
+			messages = append(messages, &Value{Pos: step.Send.Pos, Map: step.Send})
+		}
+		invoke.RequestStream = make([]map[string]any, len(messages))
+		for i, message := range messages {
+			value, valueHaveReference := message.value(lines, namedInvokes, invoke.Variables, withReference)
+			if message.Map == nil {
+				syntaxError(lines, message.Pos, 0, "Stream messages must be objects but got %v", value)
+			}
+			invoke.RequestStream[i] = value.(map[string]any)
+			haveReference = haveReference || valueHaveReference
+		}
+	}
+	//parsing Headers must run once on the parsing file, or again when they reference other invokes
+	if (parseHeaders || withReference) && invoke.Headers != nil {
 		invoke.RequestHeaders = make([]string, len(invoke.Headers))
 		for i, header := range invoke.Headers {
 			key, _ := strconv.Unquote(header.Key)
-			value, _ := strconv.Unquote(header.Value)
-			invoke.RequestHeaders[i] = fmt.Sprintf("%s=%s", key, value)
+			value, valueHaveReference := header.Value.value(lines, namedInvokes, invoke.Variables, withReference)
+			invoke.RequestHeaders[i] = fmt.Sprintf("%s: %v", key, value)
+			haveReference = haveReference || valueHaveReference
 		}
 	}
+	invoke.ContaineReferences = haveReference
 	return nil
 }
 
+// references returns the names of the invokes the value refers to.
+func (value Value) references() []string {
+	names := make([]string, 0)
+	if value.Reference != nil {
+		names = append(names, value.Reference.Parts[0].Obj)
+		names = append(names, value.Reference.references()...)
+	} else if value.Map != nil {
+		for _, entry := range value.Map.Entries {
+			names = append(names, entry.Value.references()...)
+		}
+	} else if value.Array != nil {
+		for _, element := range value.Array.Elements {
+			names = append(names, element.references()...)
+		}
+	}
+	return names
+}
+
+// References returns the names of the invokes which this invoke reads their
+// response or data.
+func (invoke *Invoke) References() []string {
+	names := make([]string, 0)
+	for _, data := range invoke.Data {
+		names = append(names, data.Value.references()...)
+	}
+	for _, header := range invoke.Headers {
+		names = append(names, header.Value.references()...)
+	}
+	if invoke.Stream != nil {
+		names = append(names, Value{Array: invoke.Stream}.references()...)
+	}
+	expects := append([]*Expect{}, invoke.SourceExpects...)
+	for _, step := range invoke.Exchange {
+		names = append(names, Value{Map: step.Send}.references()...)
+		expects = append(expects, step.Expects...)
+	}
+	if invoke.Retry != nil {
+		expects = append(expects, invoke.Retry.Until...)
+	}
+	for _, expect := range expects {
+		for _, arg := range expect.Function.Args {
+			names = append(names, arg.references()...)
+		}
+		names = append(names, expect.Path.references()...)
+	}
+	return names
+}
+
+// referenceRoots are what an invoke exposes to other invokes and expects.
+var referenceRoots = map[string]bool{
+	"response":  true,
+	"responses": true,
+	"data":      true,
+	"headers":   true,
+	"trailers":  true,
+	"details":   true,
+}
+
+// referenceRoot is the object other invokes and expects resolve
+// "response", "responses", "data", "headers", "trailers" and "details" paths
+// against.
+func (invoke *Invoke) referenceRoot() map[string]any {
+	responses := make([]any, len(invoke.Responses))
+	for i, response := range invoke.Responses {
+		responses[i] = response
+	}
+	root := map[string]any{
+		"responses": responses,
+		"data":      invoke.RequestData,
+		"headers":   invoke.ResponseHeaders,
+		"trailers":  invoke.ResponseTrailers,
+		"details":   invoke.Details,
+	}
+	if invoke.Response != nil {
+		root["response"] = *invoke.Response
+	}
+	return root
+}
+
 func (invoke *Invoke) ParsedDataWithReference(namedInvokes *map[string]Invoke) {
 
 }
 
+// sourceLines holds the lines of every parsed file by its name, errors are
+// reported against the lines of the file of their position.
+var sourceLines = make(map[string]*[]string)
+
 func readLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -353,23 +562,90 @@ func readLines(path string) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-func main() {
-	ctx := kong.Parse(&cli)
-
-	for _, file := range cli.Files {
-		trpc := &Trpc{}
-		trpcFile, err := os.Open(file)
-		lines, _ := readLines(file)
-		ctx.FatalIfErrorf(err, "")
-		err = parser.Parse("", trpcFile, trpc)
-		ctx.FatalIfErrorf(err, "")
-		if false {
-			repr.Println(trpc /*, repr.Hide(&lexer.Position{})*/)
+// parseFile parses a .trpc file and keeps its lines for reporting errors.
+func parseFile(file string) (*Trpc, error) {
+	trpcFile, err := os.Open(file)
+	if err != nil {
+		return nil, trpcError{ExitCode: 1, Msg: err.Error()}
+	}
+	defer trpcFile.Close()
+	lines, _ := readLines(file)
+	trpc := &Trpc{}
+	if err := parser.Parse(file, trpcFile, trpc); err != nil {
+		return nil, trpcError{ExitCode: 2, Msg: err.Error()}
+	}
+	if false {
+		repr.Println(trpc /*, repr.Hide(&lexer.Position{})*/)
+	}
+	sourceLines[file] = &lines
+	if len(trpc.Entries) > 0 {
+		trpc.Entries[0].Lines = &lines
+	}
+	return trpc, nil
+}
+
+func (cmd *runCmd) Run() error {
+	results := make([]*TestResult, 0)
+	for _, file := range cmd.Files {
+		trpc, err := parseFile(file)
+		if err != nil {
+			fmt.Println(err)
+			results = append(results, &TestResult{File: file, Err: err})
+			continue
 		}
 		if len(trpc.Entries) > 0 {
-			trpc.Entries[0].Lines = &lines
-			TasteAndRun(trpc)
+			results = append(results, TasteAndRun(trpc))
+		}
+	}
+	exitCode := PrintResults(results)
+	if err := WriteReports(results, cmd.Report); err != nil {
+		fmt.Println(err)
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	if exitCode != 0 {
+		return trpcError{ExitCode: exitCode}
+	}
+	return nil
+}
+
+// withDefaultCommand makes "trpc file.trpc" run the file as it did before
+// trpc had commands, args whose first non-flag arg names no command are the
+// args of run, so a later file named as a command is still a file.
+func withDefaultCommand(app *kong.Kong, args []string) []string {
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			return args
+		}
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		for _, command := range app.Model.Children {
+			if arg == command.Name {
+				return args
+			}
+		}
+		break
+	}
+	if len(args) == 0 {
+		return args
+	}
+	return append([]string{"run"}, args...)
+}
+
+func main() {
+	app := kong.Must(&cli)
+	ctx, err := app.Parse(withDefaultCommand(app, os.Args[1:]))
+	app.FatalIfErrorf(err)
+	if err := ctx.Run(); err != nil {
+		if err.Error() != "" {
+			fmt.Println(err)
 		}
+		os.Exit(exitCodeOf(err))
 	}
 }
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/fullstorydev/grpcurl"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithDefaultCommand(t *testing.T) {
+	app := kong.Must(&cli)
+	for _, test := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"a.trpc"}, []string{"run", "a.trpc"}},
+		{[]string{"a.trpc", "b.trpc"}, []string{"run", "a.trpc", "b.trpc"}},
+		{[]string{"check", "a.trpc"}, []string{"check", "a.trpc"}},
+		{[]string{"a.trpc", "check"}, []string{"run", "a.trpc", "check"}},
+		{[]string{"--parallel=4", "a.trpc", "fmt"}, []string{"run", "--parallel=4", "a.trpc", "fmt"}},
+		{[]string{"--report", "junit=r.xml", "check"}, []string{"run", "--report", "junit=r.xml", "check"}},
+		{[]string{"-h"}, []string{"-h"}},
+		{[]string{"a.trpc", "--help"}, []string{"run", "a.trpc", "--help"}},
+		{[]string{"--", "list"}, []string{"run", "--", "list"}},
+	} {
+		if got := withDefaultCommand(app, test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("withDefaultCommand(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// testHeader is the header every .trpc file of the tests starts with.
+const testHeader = `test "test" desc "a test" trpc "v0.0.1"`
+
+// parseTrpc parses the source as the .trpc file name.
+func parseTrpc(t *testing.T, name string, source string) *Trpc {
+	t.Helper()
+	trpc := &Trpc{}
+	if err := parser.ParseString(name, source, trpc); err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	lines := strings.Split(source, "\n")
+	sourceLines[name] = &lines
+	trpc.Entries[0].Lines = &lines
+	return trpc
+}
+
+func TestInvokeHeaders(t *testing.T) {
+	trpc := parseTrpc(t, "headers.trpc", `invoke a ep pkg.Service Get headers {
+	"Authorization": "Bearer t:1",
+	"x-id": 7,
+	"x-token-bin": "AQID"
+}`)
+	invoke := trpc.Entries[0].Invoke
+	invoke.Parse(trpc.Entries[0].Lines, &NamedInvokes{}, false, true)
+	want := []string{"Authorization: Bearer t:1", "x-id: 7", "x-token-bin: AQID"}
+	if !reflect.DeepEqual(invoke.RequestHeaders, want) {
+		t.Errorf("RequestHeaders = %q, want %q", invoke.RequestHeaders, want)
+	}
+	// the RPC is called with the metadata grpcurl makes of the headers
+	md := grpcurl.MetadataFromHeaders(invoke.RequestHeaders)
+	wantMD := metadata.MD{"authorization": {"Bearer t:1"}, "x-id": {"7"}, "x-token-bin": {"\x01\x02\x03"}}
+	if !reflect.DeepEqual(md, wantMD) {
+		t.Errorf("metadata = %q, want %q", md, wantMD)
+	}
+}
+
+// recoverError runs fn and returns what it panicked with, reports of the
+// panic are not printed.
+func recoverError(t *testing.T, fn func()) (err error) {
+	t.Helper()
+	captureOutput(t, func() {
+		defer func() { err = recoveredError(recover()) }()
+		fn()
+	})
+	return err
+}
+
+func TestInterpolate(t *testing.T) {
+	variables := Variables{
+		"HOST": "localhost",
+		"PORT": int64(50051),
+		"USER": map[string]any{"name": "a"},
+		"IDS":  []any{int64(1), int64(2)},
+		"row":  map[string]any{"s": "x", "tags": []any{"t"}},
+	}
+	login := &Invoke{Name: "login", Response: &map[string]any{"token": "secret"}}
+	namedInvokes := NamedInvokes{"login": login}
+	for _, test := range []struct {
+		str           string
+		withReference bool
+		want          string
+		haveReference bool
+	}{
+		{"plain", false, "plain", false},
+		{"${HOST}:${PORT}", false, "localhost:50051", false},
+		{"${ HOST }", false, "localhost", false},
+		{"user ${USER} ids ${IDS}", false, `user {"name":"a"} ids [1,2]`, false},
+		{"$HOST {HOST}", false, "$HOST {HOST}", false},
+		// paths are resolved as references to variables are
+		{"${USER.name} ${IDS[1]} ${IDS[-1]}", false, "a 2 2", false},
+		{"${row.s}-${row.tags[0]}", false, "x-t", false},
+		// a reference to an invoke waits for invokes to be referenced
+		{"Bearer ${login.response.token}", false, "Bearer ${login.response.token}", true},
+		{"Bearer ${login.response.token}", true, "Bearer secret", true},
+	} {
+		got, haveReference := interpolate(&[]string{""}, lexer.Position{Line: 1}, test.str, &namedInvokes, variables, test.withReference)
+		if got != test.want || haveReference != test.haveReference {
+			t.Errorf("interpolate(%q, %v) = %q, %v, want %q, %v", test.str, test.withReference, got, haveReference, test.want, test.haveReference)
+		}
+	}
+
+	for _, test := range []struct {
+		str string
+		err string
+	}{
+		{"${NOPE}", "Unknown variable NOPE in ${NOPE}"},
+		{"${NOPE.a}", "Unknown variable NOPE in ${NOPE.a}"},
+		{"${}", "Invalid path ${} in ${}"},
+		{"${USER.}", "Invalid path ${USER.} in ${USER.}"},
+		{"${HOST", "Unclosed ${ in ${HOST"},
+		{"${USER.name[0]}", "Invalid variable reference USER.name[0]"},
+		{"${login.token}", "Invalid reference: login.token"},
+	} {
+		err := recoverError(t, func() {
+			interpolate(&[]string{""}, lexer.Position{Line: 1}, test.str, &namedInvokes, variables, true)
+		})
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) || exitCodeOf(err) != 2 {
+			t.Errorf("interpolate(%q): error %v, want %q", test.str, err, test.err)
+		}
+	}
+}
+
+func TestInterpolateData(t *testing.T) {
+	trpc := parseTrpc(t, "data.trpc", testHeader+`
+endpoint local "127.0.0.1" port 50051
+invoke login local pkg.Service Login data { }
+invoke get local pkg.Service Get cases [{id: 1}, {id: 2}] data {
+	key: "id-${row.id}"
+	auth: "Bearer ${login.response.token}"
+}`)
+	run := newTestRun(trpc.Entries[0])
+	run.load(trpc, "")
+	for i, name := range []string{"get[0]", "get[1]"} {
+		invoke := run.namedInvokes[name]
+		if key := invoke.RequestData["key"]; key != fmt.Sprintf("id-%d", i+1) {
+			t.Errorf("%s: key %v, want id-%d", name, key, i+1)
+		}
+		if !invoke.ContaineReferences {
+			t.Errorf("%s: interpolated reference to login is not a reference", name)
+		}
+	}
+}
+
+func TestLetValues(t *testing.T) {
+	t.Setenv("TRPC_TEST_HOST", "example.com")
+	os.Unsetenv("TRPC_TEST_UNSET")
+	trpc := parseTrpc(t, "let.trpc", testHeader+`
+let HOST = env("TRPC_TEST_HOST", "localhost")
+let PORT = env("TRPC_TEST_UNSET", 8080)
+let URL = "https://${HOST}:${PORT}/v1"
+let USER = { name: "u", tags: ["a", "b"] }
+let FIRST = USER.tags[0]`)
+	run := newTestRun(trpc.Entries[0])
+	run.load(trpc, "")
+	want := Variables{
+		"HOST":  "example.com",
+		"PORT":  int64(8080),
+		"URL":   "https://example.com:8080/v1",
+		"USER":  map[string]any{"name": "u", "tags": []any{"a", "b"}},
+		"FIRST": "a",
+	}
+	if !reflect.DeepEqual(run.variables, want) {
+		t.Errorf("variables = %#v, want %#v", run.variables, want)
+	}
+}
+
+func TestLetErrors(t *testing.T) {
+	os.Unsetenv("TRPC_TEST_UNSET")
+	for _, test := range []struct {
+		source string
+		err    string
+	}{
+		{`let A = env("TRPC_TEST_UNSET")`, "Environment variable TRPC_TEST_UNSET is not set and has no default"},
+		{`let A = "${B}"`, "Unknown variable B in ${B}"},
+		{`let A = B`, "Unknown variable B"},
+		{"let A = 1\nlet A = 2", "Duplicate variable A"},
+	} {
+		trpc := parseTrpc(t, "let.trpc", testHeader+"\n"+test.source)
+		err := recoverError(t, func() {
+			newTestRun(trpc.Entries[0]).load(trpc, "")
+		})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.source, err, test.err)
+		}
+	}
+}
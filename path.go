@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trpc/functions"
+)
+
+// PathExpr picks values out of responses, data blocks and variables:
+//
+//	items[0].id        field and index
+//	items[-1]          index from the end
+//	items[1:3]         slice, items[:2] and items[1:] too
+//	items[*].id        every item of a list or value of an object
+//	items..id          id at any depth under items
+//	items[?(@.n > 1)]  items satisfying the filter
+//
+// A path using any of the last four is multi-valued and resolves to a list of
+// the values it matched.
+type PathExpr struct {
+	Parts []Part `@@ ( "." @@ )*`
+}
+
+func (p PathExpr) String() string {
+	parts := []string{}
+	for _, part := range p.Parts {
+		parts = append(parts, part.String())
+	}
+	return strings.Join(parts, ".")
+}
+
+// Multi tells the path resolves to a list of matches rather than one value.
+func (p PathExpr) Multi() bool {
+	for _, part := range p.Parts {
+		if part.Recursive {
+			return true
+		}
+		for _, acc := range part.Acc {
+			if acc.multi() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// references returns the names of the invokes the filters of the path refer to.
+func (p PathExpr) references() []string {
+	names := make([]string, 0)
+	for _, part := range p.Parts {
+		for _, acc := range part.Acc {
+			if acc.Filter != nil && acc.Filter.Value != nil {
+				names = append(names, acc.Filter.Value.references()...)
+			}
+		}
+	}
+	return names
+}
+
+type Part struct {
+	Recursive bool   `@"."?`
+	Obj       string `@Ident`
+	Acc       []Acc  `("[" @@ "]")*`
+	//Param []Value `| @("(" @@ ")")`
+}
+
+func (p Part) String() string {
+	str := p.Obj
+	if p.Recursive {
+		str = "." + str
+	}
+	if len(p.Acc) > 0 {
+		for _, acc := range p.Acc {
+			str = str + fmt.Sprintf("[%s]", acc.String())
+		}
+	}
+	return str
+}
+
+// Index is a list index, negative indexes count from the end of the list.
+type Index int
+
+func (i *Index) Capture(values []string) error {
+	n, err := strconv.Atoi(strings.Join(values, ""))
+	*i = Index(n)
+	return err
+}
+
+type Acc struct {
+	Wildcard bool      `  @"*"`
+	Filter   *Filter   `| "?" "(" @@ ")"`
+	StrIndex *string   `| @(String|Char|RawString)`
+	IntIndex *Index    `| ( @("-"? Int)`
+	Slice    *SliceEnd `    @@? )`
+	SliceTo  *SliceEnd `| @@`
+}
+
+// SliceEnd is the end of a slice, the length of the list when it is omitted.
+type SliceEnd struct {
+	End *Index `":" @("-"? Int)?`
+}
+
+func (a Acc) String() string {
+	switch {
+	case a.Wildcard:
+		return "*"
+	case a.Filter != nil:
+		return "?(" + a.Filter.String() + ")"
+	case a.StrIndex != nil:
+		return *a.StrIndex
+	case a.SliceTo != nil:
+		return a.SliceTo.String()
+	case a.Slice != nil:
+		return fmt.Sprintf("%d%s", *a.IntIndex, a.Slice)
+	default:
+		return fmt.Sprintf("%d", *a.IntIndex)
+	}
+}
+
+func (s SliceEnd) String() string {
+	if s.End == nil {
+		return ":"
+	}
+	return fmt.Sprintf(":%d", *s.End)
+}
+
+func (a Acc) multi() bool {
+	return a.Wildcard || a.Filter != nil || a.Slice != nil || a.SliceTo != nil
+}
+
+// Filter keeps the items for which the path from the item, @ or @.path, has a
+// value or compares to Value by Op.
+type Filter struct {
+	Path  []Part `"@" ( "." @@ )*`
+	Op    string `( @( "=" "=" | "!" "=" | ( "<" | ">" ) "="? )`
+	Value *Value `  @@ )?`
+}
+
+func (f Filter) String() string {
+	str := PathExpr{Parts: append([]Part{{Obj: "@"}}, f.Path...)}.String()
+	if f.Op == "" {
+		return str
+	}
+	return fmt.Sprintf("%s %s %s", str, f.Op, f.Value.source())
+}
+
+// source writes a literal value of a filter back as it is written in a .trpc file.
+func (value Value) source() string {
+	switch {
+	case value.String != nil:
+		return *value.String
+	case value.Reference != nil:
+		return value.Reference.String()
+	case value.RawString != nil:
+		return *value.RawString
+	case value.Float != nil:
+		return fmt.Sprint(*value.Float)
+	case value.Int != nil:
+		return fmt.Sprint(*value.Int)
+	case value.Bool != nil:
+		return fmt.Sprint(bool(*value.Bool))
+	}
+	return "..."
+}
+
+var filterOps = map[string]func(val, arg any) bool{
+	"==": functions.Equal,
+	"!=": func(val, arg any) bool { return !functions.Equal(val, arg) },
+	"<":  orderedBy(func(c int) bool { return c < 0 }),
+	"<=": orderedBy(func(c int) bool { return c <= 0 }),
+	">":  orderedBy(func(c int) bool { return c > 0 }),
+	">=": orderedBy(func(c int) bool { return c >= 0 }),
+}
+
+// orderedBy compares numbers, or strings holding them, by their value and
+// other strings lexically, values of other types never match.
+func orderedBy(ok func(c int) bool) func(val, arg any) bool {
+	return func(val, arg any) bool {
+		if a, isNum := functions.ToNumber(val); isNum {
+			if b, isNum := functions.ToNumber(arg); isNum {
+				switch {
+				case a < b:
+					return ok(-1)
+				case a > b:
+					return ok(1)
+				}
+				return ok(0)
+			}
+		}
+		a, isStr := val.(string)
+		b, isStr2 := arg.(string)
+		return isStr && isStr2 && ok(strings.Compare(a, b))
+	}
+}
+
+// resolvePath walks parts over the maps and arrays built from responses or
+// data blocks and returns the value at the end of the path, or the list of
+// matches of a multi-valued path. eval evaluates the values of filters.
+//
+// A field or index which does not exist is an error, except while matching
+// many values where only the values having it are kept. Still a field found
+// on none of them is an error since it is most likely misspelled.
+func resolvePath(node any, parts []Part, eval func(*Value) any) (any, error) {
+	nodes := []any{node}
+	multi := false
+	for _, part := range parts {
+		if part.Recursive {
+			nodes = descendants(nodes, part.Obj)
+			multi = true
+		} else {
+			next := make([]any, 0, len(nodes))
+			objects := 0
+			for _, node := range nodes {
+				object, ok := node.(map[string]any)
+				if !ok {
+					if multi {
+						continue
+					}
+					return nil, fmt.Errorf("%s: (%T) is not an object", part.Obj, node)
+				}
+				objects++
+				val, ok := object[part.Obj]
+				if !ok {
+					if multi {
+						continue
+					}
+					return nil, fmt.Errorf("field %s not found, available fields are %s", part.Obj, fieldNames(object))
+				}
+				next = append(next, val)
+			}
+			if multi && len(next) == 0 && objects > 0 {
+				return nil, fmt.Errorf("field %s not found on any of the %d matched object(s)", part.Obj, objects)
+			}
+			nodes = next
+		}
+		for _, acc := range part.Acc {
+			next := make([]any, 0, len(nodes))
+			for _, node := range nodes {
+				vals, err := acc.index(node, eval)
+				if err != nil {
+					if multi {
+						continue
+					}
+					return nil, fmt.Errorf("%s: %v", part, err)
+				}
+				next = append(next, vals...)
+			}
+			nodes = next
+			multi = multi || acc.multi()
+		}
+	}
+	if multi {
+		return nodes, nil
+	}
+	return nodes[0], nil
+}
+
+func fieldNames(object map[string]any) string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// children are the items of a list or the values of an object by their key.
+func children(node any) []any {
+	switch container := node.(type) {
+	case []any:
+		return container
+	case map[string]any:
+		keys := make([]string, 0, len(container))
+		for key := range container {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		vals := make([]any, len(keys))
+		for i, key := range keys {
+			vals[i] = container[key]
+		}
+		return vals
+	}
+	return nil
+}
+
+// descendants finds the values of field name at any depth under nodes.
+func descendants(nodes []any, name string) []any {
+	found := make([]any, 0)
+	for _, node := range nodes {
+		if object, ok := node.(map[string]any); ok {
+			if val, ok := object[name]; ok {
+				found = append(found, val)
+			}
+		}
+		found = append(found, descendants(children(node), name)...)
+	}
+	return found
+}
+
+// index applies the accessor on node and returns the values it picked, one for
+// a key or an index and any number for the others.
+func (a Acc) index(node any, eval func(*Value) any) ([]any, error) {
+	if a.Wildcard {
+		if _, ok := node.([]any); !ok {
+			if _, ok := node.(map[string]any); !ok {
+				return nil, fmt.Errorf("(%T) has no items for *", node)
+			}
+		}
+		return children(node), nil
+	}
+	if a.Filter != nil {
+		if _, ok := node.([]any); !ok {
+			if _, ok := node.(map[string]any); !ok {
+				return nil, fmt.Errorf("(%T) has no items to filter", node)
+			}
+		}
+		return a.Filter.apply(children(node), eval), nil
+	}
+	switch indexed := node.(type) {
+	case map[string]any:
+		if a.Slice != nil || a.SliceTo != nil {
+			return nil, fmt.Errorf("object can not be sliced")
+		}
+		key := a.String()
+		if a.StrIndex != nil {
+			if unQuoted, err := strconv.Unquote(key); err == nil {
+				key = unQuoted
+			}
+		}
+		if val, ok := indexed[key]; ok {
+			return []any{val}, nil
+		}
+		return nil, fmt.Errorf("key %s not found", key)
+	case []any:
+		if a.StrIndex != nil {
+			return nil, fmt.Errorf("array index must be a number but got %s", *a.StrIndex)
+		}
+		if a.Slice != nil || a.SliceTo != nil {
+			return slice(indexed, a), nil
+		}
+		i := int(*a.IntIndex)
+		if i < 0 {
+			i += len(indexed)
+		}
+		if i < 0 || i >= len(indexed) {
+			return nil, fmt.Errorf("index %d out of range, length is %d", *a.IntIndex, len(indexed))
+		}
+		return []any{indexed[i]}, nil
+	default:
+		return nil, fmt.Errorf("(%T) can not be indexed by %s", node, a)
+	}
+}
+
+// slice returns the items from start up to end, the bounds are clamped to the list.
+func slice(list []any, a Acc) []any {
+	bound := func(i *Index, missing int) int {
+		if i == nil {
+			return missing
+		}
+		n := int(*i)
+		if n < 0 {
+			n += len(list)
+		}
+		if n < 0 {
+			return 0
+		}
+		if n > len(list) {
+			return len(list)
+		}
+		return n
+	}
+	end := a.SliceTo
+	if a.Slice != nil {
+		end = a.Slice
+	}
+	start, stop := bound(a.IntIndex, 0), bound(end.End, len(list))
+	if start >= stop {
+		return []any{}
+	}
+	return list[start:stop]
+}
+
+func (f Filter) apply(items []any, eval func(*Value) any) []any {
+	compare := filterOps[f.Op]
+	var arg any
+	if compare != nil && eval != nil {
+		arg = eval(f.Value)
+	}
+	matched := make([]any, 0)
+	for _, item := range items {
+		val, err := resolvePath(item, f.Path, eval)
+		if err != nil {
+			continue
+		}
+		if compare == nil && val != nil || compare != nil && compare(val, arg) {
+			matched = append(matched, item)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func parsePath(t *testing.T, path string) *PathExpr {
+	expr := &PathExpr{}
+	if err := pathParser.ParseString("", path, expr); err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	return expr
+}
+
+func pathData() map[string]any {
+	return map[string]any{
+		"items": []any{
+			map[string]any{"id": int64(1), "n": int64(1), "tags": []any{"a"}},
+			map[string]any{"id": int64(2), "n": int64(5), "sub": map[string]any{"id": int64(20)}},
+			map[string]any{"id": int64(3), "n": int64(3)},
+		},
+		"meta": map[string]any{"total": int64(3), "key-x": "v"},
+	}
+}
+
+func literalEval(value *Value) any {
+	val, _ := value.value(&[]string{}, nil, nil, false)
+	return val
+}
+
+func TestResolvePath(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		want  any
+		multi bool
+	}{
+		{path: "items[0].id", want: int64(1)},
+		{path: "items[-1].id", want: int64(3)},
+		{path: `meta["key-x"]`, want: "v"},
+		{path: "meta.total", want: int64(3)},
+		{path: "items[1:3].id", want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[:2].id", want: []any{int64(1), int64(2)}, multi: true},
+		{path: "items[1:].id", want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[-2:].id", want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[:-2].id", want: []any{int64(1)}, multi: true},
+		{path: "items[-10:1].id", want: []any{int64(1)}, multi: true},
+		{path: "items[:10].id", want: []any{int64(1), int64(2), int64(3)}, multi: true},
+		{path: "items[2:1]", want: []any{}, multi: true},
+		{path: "items[*].id", want: []any{int64(1), int64(2), int64(3)}, multi: true},
+		{path: "meta[*]", want: []any{"v", int64(3)}, multi: true},
+		{path: "items..id", want: []any{int64(1), int64(2), int64(20), int64(3)}, multi: true},
+		{path: "items[*].sub.id", want: []any{int64(20)}, multi: true},
+		{path: "items[*].tags[0]", want: []any{"a"}, multi: true},
+		{path: "items[?(@.n > 2)].id", want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[?(@.n >= 3)].id", want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[?(@.n < 3)].id", want: []any{int64(1)}, multi: true},
+		{path: "items[?(@.n <= 3)].id", want: []any{int64(1), int64(3)}, multi: true},
+		{path: "items[?(@.n == 5)].id", want: []any{int64(2)}, multi: true},
+		{path: "items[?(@.n != 5)].id", want: []any{int64(1), int64(3)}, multi: true},
+		{path: `items[?(@.n > "2")].id`, want: []any{int64(2), int64(3)}, multi: true},
+		{path: "items[?(@.sub)].id", want: []any{int64(2)}, multi: true},
+		{path: "items[?(@.n > 9)]", want: []any{}, multi: true},
+	} {
+		expr := parsePath(t, test.path)
+		if expr.Multi() != test.multi {
+			t.Errorf("%s: Multi() = %v, want %v", test.path, expr.Multi(), test.multi)
+		}
+		got, err := resolvePath(pathData(), expr.Parts, literalEval)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestResolvePathErrors(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		err  string
+	}{
+		{"items[0].nope", "field nope not found, available fields are id, n, tags"},
+		{"items[*].nope", "field nope not found on any of the 3 matched object(s)"},
+		{"items[3]", "items[3]: index 3 out of range, length is 3"},
+		{"items[-4]", "items[-4]: index -4 out of range, length is 3"},
+		{`items["a"]`, `items["a"]: array index must be a number but got "a"`},
+		{"meta[0]", "meta[0]: key 0 not found"},
+		{"meta[1:]", "meta[1:]: object can not be sliced"},
+		{"meta.total[0]", "total[0]: (int64) can not be indexed by 0"},
+		{"meta.total[*]", "total[*]: (int64) has no items for *"},
+		{"meta.total[?(@.n)]", "total[?(@.n)]: (int64) has no items to filter"},
+		{"meta.total.x", "x: (int64) is not an object"},
+	} {
+		_, err := resolvePath(pathData(), parsePath(t, test.path).Parts, literalEval)
+		if err == nil {
+			t.Errorf("%s: expected error %q", test.path, test.err)
+		} else if err.Error() != test.err {
+			t.Errorf("%s: error %q, want %q", test.path, err, test.err)
+		}
+	}
+}
+
+func TestPathString(t *testing.T) {
+	for _, path := range []string{
+		"items[0].id",
+		"items[-1]",
+		"items[1:3]",
+		"items[:2]",
+		"items[1:]",
+		"items[*].id",
+		"items..id",
+		`meta["key-x"]`,
+		"items[?(@.n > 2)]",
+		"items[?(@.sub)]",
+	} {
+		if got := parsePath(t, path).String(); got != path {
+			t.Errorf("String() = %s, want %s", got, path)
+		}
+	}
+}
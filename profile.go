@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// profileFileName is the sidecar of .trpc files in the same directory which
+// holds profiles as JSON:
+//
+//	{
+//	  "staging": {
+//	    "variables": { "HOST": "staging.example.com" },
+//	    "endpoints": { "local": { "host": "staging.example.com", "port": 443, "cacert": "ca.pem" } }
+//	  }
+//	}
+const profileFileName = "trpc.env.json"
+
+// Profile overrides variables and endpoints of the file when it is selected
+// by --profile:
+//
+//	profile "staging" {
+//	  let HOST = "staging.example.com"
+//	  endpoint local tls "${HOST}" port 443
+//	}
+type Profile struct {
+	Pos lexer.Position
+
+	Name    string          `"profile" @String "{"`
+	Entries []*ProfileEntry `( @@ ";"* )* "}"`
+}
+
+type ProfileEntry struct {
+	Pos lexer.Position
+
+	Let      *Let      `  @@`
+	Endpoint *Endpoint `| @@`
+}
+
+type jsonProfile struct {
+	Variables map[string]any          `json:"variables"`
+	Endpoints map[string]jsonEndpoint `json:"endpoints"`
+}
+
+// jsonEndpoint only overrides the fields which are set, the options are named
+// as in the file.
+type jsonEndpoint struct {
+	Host               *string `json:"host"`
+	Port               *int    `json:"port"`
+	Tls                *bool   `json:"tls"`
+	CACert             *string `json:"cacert"`
+	Cert               *string `json:"cert"`
+	Key                *string `json:"key"`
+	InsecureSkipVerify *bool   `json:"insecureSkipVerify"`
+	Authority          *string `json:"authority"`
+	ServerName         *string `json:"serverName"`
+}
+
+// profileOverrides is what the selected profile replaces in the file.
+type profileOverrides struct {
+	variables     map[string]bool
+	endpoints     map[string]*Endpoint
+	jsonEndpoints map[string]jsonEndpoint
+}
+
+// fromJSON makes numbers of a JSON profile look like the numbers of a .trpc file.
+func fromJSON(value any) any {
+	switch val := value.(type) {
+	case float64:
+		if val == math.Trunc(val) {
+			return int64(val)
+		}
+	case map[string]any:
+		for k, v := range val {
+			val[k] = fromJSON(v)
+		}
+	case []any:
+		for i, v := range val {
+			val[i] = fromJSON(v)
+		}
+	}
+	return value
+}
+
+func readJSONProfiles(dir string) (map[string]jsonProfile, error) {
+	content, err := os.ReadFile(filepath.Join(dir, profileFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]jsonProfile)
+	if err := json.Unmarshal(content, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %v", profileFileName, err)
+	}
+	return profiles, nil
+}
+
+// loadProfile declares the variables of the selected profile before any
+// variable of the file, the profile blocks of the file come first and then
+// the sidecar JSON.
+func (run *testRun) loadProfile(trpc *Trpc, name string) {
+	lines := run.mainEntery.Lines
+	run.profile = &profileOverrides{
+		variables:     make(map[string]bool),
+		endpoints:     make(map[string]*Endpoint),
+		jsonEndpoints: make(map[string]jsonEndpoint),
+	}
+	declared := make(map[string]bool)
+	for _, entery := range trpc.Entries {
+		if entery.Endpoint != nil {
+			declared[entery.Endpoint.Name] = true
+		}
+	}
+	found := false
+	for _, entery := range trpc.Entries {
+		if entery.Profile == nil {
+			continue
+		}
+		if profileName, _ := strconv.Unquote(entery.Profile.Name); profileName != name {
+			continue
+		}
+		found = true
+		for _, profileEntry := range entery.Profile.Entries {
+			if profileEntry.Let != nil {
+				value, haveReference := profileEntry.Let.Value.value(lines, &run.namedInvokes, run.variables, false)
+				if haveReference {
+					syntaxError(lines, profileEntry.Let.Value.Pos, 0, "Variable %s can not reference invokes", profileEntry.Let.Name)
+				}
+				run.variables[profileEntry.Let.Name] = value
+				run.profile.variables[profileEntry.Let.Name] = true
+			}
+			if profileEntry.Endpoint != nil {
+				if !declared[profileEntry.Endpoint.Name] {
+					syntaxError(lines, profileEntry.Endpoint.Pos, 0, "Profile \"%s\" overrides endpoint %s which the file does not declare", name, profileEntry.Endpoint.Name)
+				}
+				run.profile.endpoints[profileEntry.Endpoint.Name] = profileEntry.Endpoint
+			}
+		}
+	}
+
+	profiles, err := readJSONProfiles(filepath.Dir(run.mainEntery.Pos.Filename))
+	if err != nil {
+		panic(trpcError{ExitCode: 2, Msg: err.Error()})
+	}
+	if profile, ok := profiles[name]; ok {
+		found = true
+		for variable, value := range profile.Variables {
+			run.variables[variable] = fromJSON(value)
+			run.profile.variables[variable] = true
+		}
+		for endpoint, override := range profile.Endpoints {
+			if !declared[endpoint] {
+				panic(trpcError{ExitCode: 2, Msg: fmt.Sprintf("Profile \"%s\" of %s overrides endpoint %s which the file does not declare", name, profileFileName, endpoint)})
+			}
+			run.profile.jsonEndpoints[endpoint] = override
+		}
+	}
+
+	if !found {
+		panic(trpcError{ExitCode: 2, Msg: fmt.Sprintf("Profile \"%s\" not found in the file nor in %s", name, profileFileName)})
+	}
+}
+
+// profileEndpoint returns the endpoint which is used in place of the declared
+// one, with host and port resolved.
+func (run *testRun) profileEndpoint(endpoint *Endpoint) *Endpoint {
+	if run.profile != nil {
+		if override, ok := run.profile.endpoints[endpoint.Name]; ok {
+			endpoint = override
+		}
+	}
+	run.resolveEndpoint(endpoint)
+	if run.profile == nil {
+		return endpoint
+	}
+	if override, ok := run.profile.jsonEndpoints[endpoint.Name]; ok {
+		if override.Host != nil {
+			endpoint.IPDomain = *override.Host
+		}
+		if override.Port != nil {
+			endpoint.Port = *override.Port
+		}
+		if override.Tls != nil {
+			endpoint.Tls = *override.Tls
+		}
+		if override.CACert != nil {
+			endpoint.CACert = *override.CACert
+		}
+		if override.Cert != nil {
+			endpoint.Cert = *override.Cert
+		}
+		if override.Key != nil {
+			endpoint.Key = *override.Key
+		}
+		if override.InsecureSkipVerify != nil {
+			endpoint.Insecure = *override.InsecureSkipVerify
+		}
+		if override.Authority != nil {
+			endpoint.Authority = *override.Authority
+		}
+		if override.ServerName != nil {
+			endpoint.ServerName = *override.ServerName
+		}
+		if (endpoint.Cert == "") != (endpoint.Key == "") {
+			panic(trpcError{ExitCode: 2, Msg: fmt.Sprintf("Endpoint %s of %s needs both cert and key", endpoint.Name, profileFileName)})
+		}
+		if impliesTls(endpoint.CACert, endpoint.Cert, endpoint.Insecure, endpoint.ServerName) {
+			endpoint.Tls = true
+		}
+	}
+	return endpoint
+}
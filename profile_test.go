@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files by their names into a new directory, it returns
+// the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// loadFile parses and loads the file as run does, with the profile when it
+// is not empty.
+func loadFile(t *testing.T, path string, profile string) *testRun {
+	t.Helper()
+	trpc, err := parseFile(path)
+	if err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	run := newTestRun(trpc.Entries[0])
+	run.load(trpc, profile)
+	return run
+}
+
+const profileSource = testHeader + `
+let HOST = "localhost"
+let PORT = 50051
+let NAME = "${HOST}:${PORT}"
+endpoint local "${HOST}" port PORT
+endpoint secure tls "secure.local" port 443 cacert "ca.pem"
+profile "staging" {
+	let HOST = "staging.example.com"
+	endpoint secure tls "${HOST}" port 8443 serverName "api"
+}
+`
+
+func TestProfiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.trpc": profileSource,
+		profileFileName: `{
+  "staging": {
+    "variables": { "PORT": 9000, "RATIO": 0.5, "IDS": [1, 2] },
+    "endpoints": { "local": { "tls": true, "cacert": "staging-ca.pem" } }
+  },
+  "json": {
+    "endpoints": { "secure": { "host": "json.example.com", "port": 1443, "cert": "c.pem", "key": "k.pem", "insecureSkipVerify": true } }
+  }
+}`,
+	})
+	path := filepath.Join(dir, "a.trpc")
+	for _, test := range []struct {
+		profile   string
+		variables Variables
+		endpoints map[string]Endpoint
+	}{
+		{
+			profile:   "",
+			variables: Variables{"HOST": "localhost", "PORT": int64(50051), "NAME": "localhost:50051"},
+			endpoints: map[string]Endpoint{
+				"local":  {IPDomain: "localhost", Port: 50051},
+				"secure": {Tls: true, IPDomain: "secure.local", Port: 443, CACert: "ca.pem"},
+			},
+		},
+		{
+			// the block of the file overrides HOST and secure, the JSON PORT and local
+			profile:   "staging",
+			variables: Variables{"HOST": "staging.example.com", "PORT": int64(9000), "NAME": "staging.example.com:9000", "RATIO": 0.5, "IDS": []any{int64(1), int64(2)}},
+			endpoints: map[string]Endpoint{
+				"local":  {Tls: true, IPDomain: "staging.example.com", Port: 9000, CACert: "staging-ca.pem"},
+				"secure": {Tls: true, IPDomain: "staging.example.com", Port: 8443, ServerName: "api"},
+			},
+		},
+		{
+			profile:   "json",
+			variables: Variables{"HOST": "localhost", "PORT": int64(50051), "NAME": "localhost:50051"},
+			endpoints: map[string]Endpoint{
+				"local":  {IPDomain: "localhost", Port: 50051},
+				"secure": {Tls: true, IPDomain: "json.example.com", Port: 1443, CACert: "ca.pem", Cert: "c.pem", Key: "k.pem", Insecure: true},
+			},
+		},
+	} {
+		run := loadFile(t, path, test.profile)
+		if !reflect.DeepEqual(run.variables, test.variables) {
+			t.Errorf("profile %q: variables = %#v, want %#v", test.profile, run.variables, test.variables)
+		}
+		for name, want := range test.endpoints {
+			got := run.namedEndpointes[name]
+			got = Endpoint{Tls: got.Tls, IPDomain: got.IPDomain, Port: got.Port, CACert: got.CACert, Cert: got.Cert, Key: got.Key,
+				Insecure: got.Insecure, Authority: got.Authority, ServerName: got.ServerName}
+			if got != want {
+				t.Errorf("profile %q: endpoint %s = %+v, want %+v", test.profile, name, got, want)
+			}
+		}
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		source  string
+		json    string
+		profile string
+		err     string
+	}{
+		{
+			name:    "unknown profile",
+			source:  profileSource,
+			profile: "prod",
+			err:     `Profile "prod" not found in the file nor in trpc.env.json`,
+		},
+		{
+			name:    "undeclared block endpoint",
+			source:  profileSource + `profile "dev" { endpoint other "h" port 1 }`,
+			profile: "dev",
+			err:     `Profile "dev" overrides endpoint other which the file does not declare`,
+		},
+		{
+			name:    "undeclared JSON endpoint",
+			source:  profileSource,
+			json:    `{ "dev": { "endpoints": { "other": { "port": 1 } } } }`,
+			profile: "dev",
+			err:     `Profile "dev" of trpc.env.json overrides endpoint other which the file does not declare`,
+		},
+		{
+			name:    "cert without key",
+			source:  profileSource,
+			json:    `{ "dev": { "endpoints": { "local": { "cert": "c.pem" } } } }`,
+			profile: "dev",
+			err:     "Endpoint local of trpc.env.json needs both cert and key",
+		},
+		{
+			name:    "invalid JSON",
+			source:  profileSource,
+			json:    `{ "dev": [] }`,
+			profile: "dev",
+			err:     "trpc.env.json: json: cannot unmarshal array into Go ",
+		},
+	} {
+		files := map[string]string{"a.trpc": test.source}
+		if test.json != "" {
+			files[profileFileName] = test.json
+		}
+		path := filepath.Join(writeFiles(t, files), "a.trpc")
+		err := recoverError(t, func() { loadFile(t, path, test.profile) })
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	got := fromJSON(map[string]any{"n": 3.0, "f": 1.5, "l": []any{2.0, "a"}, "m": map[string]any{"n": -1.0}})
+	want := map[string]any{"n": int64(3), "f": 1.5, "l": []any{int64(2), "a"}, "m": map[string]any{"n": int64(-1)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fromJSON = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// reportWriters maps a --report format to the function writing it.
+var reportWriters = map[string]func([]*TestResult, string) error{
+	"junit": writeJUnitReport,
+	"json":  writeJSONReport,
+}
+
+// WriteReports writes every requested report, formats maps a report format to
+// the path of the report file.
+func WriteReports(results []*TestResult, formats map[string]string) error {
+	for format, path := range formats {
+		writer, ok := reportWriters[format]
+		if !ok {
+			return fmt.Errorf("Unknown report format \"%s\", use junit or json", format)
+		}
+		if err := writer(results, path); err != nil {
+			return fmt.Errorf("Failed to write %s report %s: %v", format, path, err)
+		}
+	}
+	return nil
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// expectString is how the expect is written in the .trpc file.
+func expectString(expect *Expect) string {
+	if expect.Path == nil || expect.Function == nil {
+		return "exchange step"
+	}
+	str := expect.Path.String() + " " + expect.Function.Name + "()"
+	if expect.Quantifier != nil {
+		str = *expect.Quantifier + " " + str
+	}
+	return str
+}
+
+func firstLine(msg string) string {
+	return strings.SplitN(msg, "\n", 2)[0]
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	File       string           `xml:"file,attr,omitempty"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr,omitempty"`
+	Properties *junitProperties `xml:"properties"`
+	Cases      []*junitTestCase `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Property []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	File      string         `xml:"file,attr,omitempty"`
+	Line      int            `xml:"line,attr,omitempty"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitMessage `xml:"failure"`
+	Errors    []junitMessage `xml:"error"`
+	Flaky     []junitMessage `xml:"flakyFailure"`
+	Skipped   *junitMessage  `xml:"skipped"`
+	SystemOut string         `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// junitExpectMessage tells where the expect is and why it did not pass.
+func junitExpectMessage(expect *ExpectResult, kind string, msg string) junitMessage {
+	pos := expect.Expect.Pos
+	return junitMessage{
+		Message: firstLine(msg),
+		Type:    kind,
+		Body:    fmt.Sprintf("%s:%d:%d: %s\n%s", pos.Filename, pos.Line, pos.Column, expectString(expect.Expect), msg),
+	}
+}
+
+// addInvoke adds the invoke as a testcase of the suite.
+func (suite *junitTestSuite) addInvoke(invoke *InvokeResult, className string) {
+	testCase := &junitTestCase{
+		Name:      invoke.Invoke.Name,
+		ClassName: className,
+		File:      invoke.Invoke.Pos.Filename,
+		Line:      invoke.Invoke.Pos.Line,
+		Time:      seconds(invoke.Duration),
+	}
+	output := make([]string, 0)
+	if invoke.Invoke.Goal != "" {
+		output = append(output, "Goal: "+invoke.Invoke.Goal)
+	}
+	for _, attempt := range invoke.Attempts {
+		output = append(output, attempt.String())
+	}
+	testCase.SystemOut = strings.Join(output, "\n")
+	if invoke.Err != nil {
+		testCase.Errors = append(testCase.Errors, junitMessage{Message: firstLine(invoke.Err.Error()), Body: invoke.Err.Error()})
+	}
+	if invoke.SkipReason != "" {
+		testCase.Skipped = &junitMessage{Message: invoke.SkipReason}
+	}
+	for _, expect := range invoke.Expects {
+		if expect.Err != nil {
+			testCase.Errors = append(testCase.Errors, junitExpectMessage(expect, "", expect.Err.Error()))
+		}
+		if expect.Condition == nil {
+			continue
+		}
+		message := junitExpectMessage(expect, expect.Condition.String(), expect.Condition.Msg)
+		if expect.Condition.Condition == InvokeFailed {
+			testCase.Failures = append(testCase.Failures, message)
+		} else {
+			testCase.Flaky = append(testCase.Flaky, message)
+		}
+	}
+	switch invoke.Status() {
+	case ResultFailed:
+		suite.Failures++
+	case ResultErrored:
+		suite.Errors++
+	case ResultSkipped:
+		suite.Skipped++
+	}
+	suite.Cases = append(suite.Cases, testCase)
+}
+
+// writeJUnitReport writes every file as a testsuite and every invoke as a
+// testcase of it, Warn and Ignore conditions are written as flakyFailure.
+func writeJUnitReport(results []*TestResult, path string) error {
+	suites := &junitTestSuites{}
+	var total time.Duration
+	for _, result := range results {
+		suite := &junitTestSuite{
+			Name: result.Name(),
+			File: result.File,
+			Time: seconds(result.Duration),
+		}
+		if !result.Start.IsZero() {
+			suite.Timestamp = result.Start.Format("2006-01-02T15:04:05")
+		}
+		if result.Entry != nil && result.Entry.Description != "" {
+			suite.Properties = &junitProperties{
+				Property: []junitProperty{{Name: "description", Value: result.Entry.Description}},
+			}
+		}
+		if result.Err != nil {
+			suite.Cases = append(suite.Cases, &junitTestCase{
+				Name:      result.File,
+				ClassName: result.Name(),
+				Time:      seconds(0),
+				Errors:    []junitMessage{{Message: firstLine(result.Err.Error()), Body: result.Err.Error()}},
+			})
+			suite.Errors++
+		}
+		for _, invoke := range result.Invokes {
+			suite.addInvoke(invoke, result.Name())
+		}
+		for _, invoke := range result.Teardown {
+			suite.addInvoke(invoke, result.Name()+".teardown")
+		}
+		suite.Tests = len(suite.Cases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		total += result.Duration
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = seconds(total)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	file.WriteString(xml.Header)
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err = file.WriteString("\n")
+	return err
+}
+
+type jsonReport struct {
+	ExitCode int               `json:"exitCode"`
+	Files    []*jsonFileReport `json:"files"`
+}
+
+type jsonFileReport struct {
+	File        string              `json:"file"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	ExitCode    int                 `json:"exitCode"`
+	Start       *time.Time          `json:"start,omitempty"`
+	Time        float64             `json:"time"`
+	Error       string              `json:"error,omitempty"`
+	Warnings    int                 `json:"warnings"`
+	Ignores     int                 `json:"ignores"`
+	Invokes     []*jsonInvokeReport `json:"invokes"`
+	Teardown    []*jsonInvokeReport `json:"teardown,omitempty"`
+}
+
+type jsonInvokeReport struct {
+	Name       string               `json:"name"`
+	Goal       string               `json:"goal,omitempty"`
+	Position   string               `json:"position"`
+	Status     string               `json:"status"`
+	Time       float64              `json:"time"`
+	Latency    float64              `json:"latency,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	SkipReason string               `json:"skipReason,omitempty"`
+	Attempts   []*jsonAttemptReport `json:"attempts,omitempty"`
+	Expects    []*jsonExpectReport  `json:"expects"`
+}
+
+type jsonAttemptReport struct {
+	Attempt  int      `json:"attempt"`
+	Status   string   `json:"status"`
+	Time     float64  `json:"time"`
+	Error    string   `json:"error,omitempty"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+type jsonExpectReport struct {
+	Expect   string `json:"expect"`
+	Position string `json:"position"`
+	Status   string `json:"status"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// jsonInvoke reports the invoke with every one of its expects.
+func jsonInvoke(invoke *InvokeResult) *jsonInvokeReport {
+	pos := invoke.Invoke.Pos
+	invokeReport := &jsonInvokeReport{
+		Name:       invoke.Invoke.Name,
+		Goal:       invoke.Invoke.Goal,
+		Position:   fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column),
+		Status:     resultStatusNames[invoke.Status()],
+		Time:       invoke.Duration.Seconds(),
+		Latency:    invoke.Latency.Seconds(),
+		SkipReason: invoke.SkipReason,
+		Expects:    make([]*jsonExpectReport, 0),
+	}
+	if invoke.Err != nil {
+		invokeReport.Error = invoke.Err.Error()
+	}
+	for _, attempt := range invoke.Attempts {
+		attemptReport := &jsonAttemptReport{
+			Attempt:  attempt.Attempt,
+			Status:   resultStatusNames[ResultPassed],
+			Time:     attempt.Duration.Seconds(),
+			Failures: attempt.Failures,
+		}
+		if attempt.Err != nil {
+			attemptReport.Status = resultStatusNames[ResultErrored]
+			attemptReport.Error = attempt.Err.Error()
+		} else if !attempt.Passed() {
+			attemptReport.Status = resultStatusNames[ResultFailed]
+		}
+		invokeReport.Attempts = append(invokeReport.Attempts, attemptReport)
+	}
+	for _, expect := range invoke.Expects {
+		pos := expect.Expect.Pos
+		expectReport := &jsonExpectReport{
+			Expect:   expectString(expect.Expect),
+			Position: fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column),
+			Status:   resultStatusNames[ResultPassed],
+		}
+		if expect.Err != nil {
+			expectReport.Status = resultStatusNames[ResultErrored]
+			expectReport.Error = expect.Err.Error()
+		} else if expect.Condition != nil {
+			expectReport.Status = resultStatusNames[ResultWarned]
+			if expect.Condition.Condition == InvokeFailed {
+				expectReport.Status = resultStatusNames[ResultFailed]
+			}
+			expectReport.Severity = expect.Condition.String()
+			expectReport.Message = expect.Condition.Msg
+		}
+		invokeReport.Expects = append(invokeReport.Expects, expectReport)
+	}
+	return invokeReport
+}
+
+func writeJSONReport(results []*TestResult, path string) error {
+	report := &jsonReport{Files: make([]*jsonFileReport, 0)}
+	for _, result := range results {
+		fileReport := &jsonFileReport{
+			File:     result.File,
+			Name:     result.Name(),
+			ExitCode: result.ExitCode(),
+			Time:     result.Duration.Seconds(),
+			Invokes:  make([]*jsonInvokeReport, 0),
+		}
+		if result.ExitCode() > report.ExitCode {
+			report.ExitCode = result.ExitCode()
+		}
+		if !result.Start.IsZero() {
+			fileReport.Start = &result.Start
+		}
+		if result.Entry != nil {
+			fileReport.Description = result.Entry.Description
+			fileReport.Warnings = result.Entry.Warnings
+			fileReport.Ignores = result.Entry.Ignores
+		}
+		if result.Err != nil {
+			fileReport.Error = result.Err.Error()
+		}
+		for _, invoke := range result.Invokes {
+			fileReport.Invokes = append(fileReport.Invokes, jsonInvoke(invoke))
+		}
+		for _, invoke := range result.Teardown {
+			fileReport.Teardown = append(fileReport.Teardown, jsonInvoke(invoke))
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+
+	jb, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(jb, '\n'), 0644)
+}
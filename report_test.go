@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+// golden compares got with the golden file of testdata, with -update it
+// writes got to the file instead.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file, got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func reportResults(t *testing.T) []*TestResult {
+	pos := func(line, column int) lexer.Position {
+		return lexer.Position{Filename: "orders.trpc", Line: line, Column: column}
+	}
+	expect := func(line int, path string, function string) *Expect {
+		return &Expect{Pos: pos(line, 5), Path: parsePath(t, path), Function: &Function{Name: function}}
+	}
+	condition := func(status InvokeConditionStatus, msg string) *InvokeCondition {
+		return &InvokeCondition{Condition: status, Msg: msg}
+	}
+	every := "every"
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*TestResult{
+		{
+			File:     "orders.trpc",
+			Entry:    &Entry{TestName: "orders", Description: "order service", Warnings: 1, Ignores: 1},
+			Start:    start,
+			Duration: 1500 * time.Millisecond,
+			Invokes: []*InvokeResult{
+				{
+					Invoke:   &Invoke{Name: "create", Goal: "create an order", Pos: pos(5, 1)},
+					Duration: 200 * time.Millisecond,
+					Latency:  150 * time.Millisecond,
+					Expects: []*ExpectResult{
+						{Expect: expect(7, "response.id", "hasValue")},
+						{Expect: expect(8, "response.total", "isGreaterThan"), Condition: condition(InvokeDoneWithWarnings, "response.total expected to be greater than 10 but got 5\nActual response: {}")},
+						{Expect: expect(9, "response.note", "isEmpty"), Condition: condition(InvokeDoneWithIgnores, "response.note expected to be empty")},
+					},
+				},
+				{
+					Invoke:   &Invoke{Name: "poll", Pos: pos(12, 1)},
+					Duration: 300 * time.Millisecond,
+					Attempts: []*AttemptResult{
+						{Attempt: 1, Failures: []string{"response.state expected to be \"DONE\""}, Duration: 100 * time.Millisecond},
+						{Attempt: 2, Err: errors.New("unavailable"), Duration: 50 * time.Millisecond},
+						{Attempt: 3, Duration: 100 * time.Millisecond},
+					},
+					Expects: []*ExpectResult{
+						{Expect: &Expect{Pos: pos(14, 5), Quantifier: &every, Path: parsePath(t, "responses.state"), Function: &Function{Name: "isEqual"}}, Condition: condition(InvokeFailed, "responses[1]: state expected to be \"DONE\"")},
+					},
+				},
+				{
+					Invoke:     &Invoke{Name: "cancel", Pos: pos(18, 1)},
+					SkipReason: "referenced invoke poll did not pass",
+				},
+				{
+					Invoke:  &Invoke{Name: "get", Pos: pos(20, 1)},
+					Expects: []*ExpectResult{{Expect: expect(22, "response.items[0]", "isEqual"), Err: trpcError{ExitCode: 2, Msg: "isEqual: expects 1 argument(s) but got 0"}}},
+				},
+			},
+			Teardown: []*InvokeResult{
+				{Invoke: &Invoke{Name: "cleanup", Pos: pos(26, 5)}, Duration: 10 * time.Millisecond, Expects: []*ExpectResult{{Expect: expect(27, "code", "isOK")}}},
+				{Invoke: &Invoke{Name: "drop", Pos: pos(29, 5)}, Err: errors.New("Failed to dial target host \"localhost:1\"")},
+			},
+		},
+		{
+			File: "broken.trpc",
+			Err:  trpcError{ExitCode: 2, Msg: "broken.trpc:1:1: unexpected token \"x\""},
+		},
+	}
+}
+
+func TestWriteReports(t *testing.T) {
+	dir := t.TempDir()
+	paths := map[string]string{
+		"junit": filepath.Join(dir, "report.xml"),
+		"json":  filepath.Join(dir, "report.json"),
+	}
+	if err := WriteReports(reportResults(t), paths); err != nil {
+		t.Fatal(err)
+	}
+	for format, path := range paths {
+		written, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden(t, format+"_report.golden", written)
+	}
+}
+
+func TestWriteReportsUnknownFormat(t *testing.T) {
+	err := WriteReports(nil, map[string]string{"html": "report.html"})
+	if err == nil || err.Error() != `Unknown report format "html", use junit or json` {
+		t.Errorf("WriteReports html: error %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+
+	"trpc/grpcrunner"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/fatih/color"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryInterval = time.Second
+	maxRetryWait         = time.Minute
+)
+
+// Retry calls the RPC of the invoke again until the until expects, or the
+// expects of the invoke when there are none, pass or the attempts run out:
+//
+//	retry {
+//	  attempts 10
+//	  interval "500ms"
+//	  backoff exponential
+//	  until {
+//	    response.status isEqual("SHIPPED")
+//	  }
+//	}
+//
+// The wait after an attempt is the interval for constant backoff, interval
+// times the attempt for linear and doubles after every attempt for exponential.
+type Retry struct {
+	Pos lexer.Position
+
+	Attempts *int64    `"retry" "{" ( "attempts" @Int`
+	Interval *Value    `| "interval" @@`
+	Backoff  string    `| "backoff" @( "constant" | "linear" | "exponential" )`
+	Until    []*Expect `| "until" "{" @@* "}" )* "}"`
+	// These ones are runtime extracted values
+	MaxAttempts   int
+	IntervalValue time.Duration
+}
+
+// wait is how long to wait after the attempt before the next one, a growing
+// backoff stops growing at maxRetryWait, or at the interval when it is longer.
+func (retry *Retry) wait(attempt int) time.Duration {
+	limit := maxRetryWait
+	if retry.IntervalValue > limit {
+		limit = retry.IntervalValue
+	}
+	wait := retry.IntervalValue
+	switch retry.Backoff {
+	case "linear":
+		if wait > 0 && time.Duration(attempt) > limit/wait {
+			return limit
+		}
+		wait *= time.Duration(attempt)
+	case "exponential":
+		for i := 1; i < attempt && wait < limit; i++ {
+			wait *= 2
+		}
+	}
+	if wait > limit {
+		return limit
+	}
+	return wait
+}
+
+// checkRetry validates the retry of the invoke and evaluates its interval.
+func (run *testRun) checkRetry(invoke *Invoke) {
+	lines := run.mainEntery.Lines
+	retry := invoke.Retry
+	retry.MaxAttempts = defaultRetryAttempts
+	if retry.Attempts != nil {
+		if *retry.Attempts < 1 {
+			syntaxError(lines, retry.Pos, 0, "Invoke %s must retry at least 1 attempt but got %d", invoke.Name, *retry.Attempts)
+		}
+		retry.MaxAttempts = int(*retry.Attempts)
+	}
+	retry.IntervalValue = defaultRetryInterval
+	if retry.Interval == nil {
+		return
+	}
+	retry.IntervalValue = run.durationValue(invoke, retry.Interval, "retry interval")
+}
+
+// callUntil calls the RPC of the invoke, with retry it is called until the
+// attempt passes or the attempts run out and every attempt is kept on the
+// result. The handler of the last attempt is returned.
+func (run *testRun) callUntil(invoke *Invoke, endPoint Endpoint, invokeResult *InvokeResult) (*grpcrunner.TRPCHandler, error) {
+	retry := invoke.Retry
+	if retry == nil {
+		return run.call(invoke, endPoint)
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		handler, err := run.call(invoke, endPoint)
+		attemptResult := &AttemptResult{Attempt: attempt, Err: err}
+		if err == nil {
+			attemptResult.Failures = run.attemptFailures(invoke, handler)
+		}
+		attemptResult.Duration = time.Since(start)
+		invokeResult.Attempts = append(invokeResult.Attempts, attemptResult)
+		if attemptResult.Passed() || attempt >= retry.MaxAttempts {
+			return handler, err
+		}
+		wait := retry.wait(attempt)
+		color.Yellow("Attempt %d of %d of %s did not pass, retrying in %v: %s", attempt, retry.MaxAttempts, invoke.Name, wait, attemptResult.Reason())
+		time.Sleep(wait)
+	}
+}
+
+// attemptFailures examines the until expects, or the expects of the invoke,
+// quietly and returns why they did not pass. Warn and Ignore failures do not
+// keep the invoke from passing.
+func (run *testRun) attemptFailures(invoke *Invoke, handler *grpcrunner.TRPCHandler) []string {
+	expects := invoke.Retry.Until
+	if len(expects) == 0 {
+		for i := range invoke.Expects {
+			expects = append(expects, &invoke.Expects[i])
+		}
+	}
+	conditions := len(invoke.Conditions)
+	invoke.quiet = true
+	defer func() {
+		invoke.quiet = false
+		invoke.Conditions = invoke.Conditions[:conditions]
+	}()
+	failures := make([]string, 0)
+	for _, expect := range expects {
+		expectResult := run.checkExpect(invoke, handler, expect)
+		if expectResult.Err != nil {
+			failures = append(failures, expectResult.Err.Error())
+		} else if expectResult.Condition != nil && expectResult.Condition.Condition == InvokeFailed {
+			failures = append(failures, firstLine(expectResult.Condition.Msg))
+		}
+	}
+	return failures
+}
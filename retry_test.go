@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestRetryWait(t *testing.T) {
+	for _, test := range []struct {
+		backoff  string
+		interval time.Duration
+		attempt  int
+		want     time.Duration
+	}{
+		{"", time.Second, 5, time.Second},
+		{"constant", 2 * time.Minute, 5, 2 * time.Minute},
+		{"linear", time.Second, 3, 3 * time.Second},
+		{"linear", time.Second, 1 << 62, maxRetryWait},
+		{"exponential", time.Second, 1, time.Second},
+		{"exponential", time.Second, 4, 8 * time.Second},
+		{"exponential", time.Second, 64, maxRetryWait},
+		{"exponential", time.Second, 1000, maxRetryWait},
+		{"exponential", 5 * time.Minute, 10, 5 * time.Minute},
+		{"exponential", 0, 100, 0},
+	} {
+		retry := &Retry{Backoff: test.backoff, IntervalValue: test.interval}
+		if got := retry.wait(test.attempt); got != test.want {
+			t.Errorf("%s %v attempt %d: wait %v, want %v", test.backoff, test.interval, test.attempt, got, test.want)
+		}
+	}
+}
+
+// countingServer answers the Nth unary call with the username "call-N".
+type countingServer struct {
+	testpb.UnimplementedTestServiceServer
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *countingServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return &testpb.SimpleResponse{Username: fmt.Sprintf("call-%d", s.calls)}, nil
+}
+
+func TestCallUntil(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		source   string
+		status   string
+		attempts string
+		username string
+	}{
+		{
+			name: "stops at the first passing attempt",
+			source: `retry {
+	attempts 5
+	interval 0
+	until {
+		response.username isEqual("call-3")
+	}
+}`,
+			status:   "passed",
+			attempts: "failed failed passed",
+			username: "call-3",
+		},
+		{
+			name: "warn and ignore do not fail an attempt",
+			source: `retry {
+	attempts 5
+	interval 0
+} expects {
+	response.username isEqual("call-2")
+	response.username isEqual("nope") onFail Warn
+	response.username isEqual("nope") onFail Ignore
+}`,
+			status:   "warned",
+			attempts: "failed passed",
+			username: "call-2",
+		},
+		{
+			name: "examines the last attempt",
+			source: `retry {
+	attempts 2
+	interval 0
+	until {
+		response.username isEqual("call-9")
+	}
+} expects {
+	response.username isEqual("call-2")
+}`,
+			status:   "failed",
+			attempts: "failed failed",
+			username: "call-2",
+		},
+	} {
+		server := &countingServer{}
+		port := serveTest(t, server)
+		result := runFile(t, port, "invoke poll local grpc.testing.TestService UnaryCall data {} "+test.source)
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.name, result.Err)
+			continue
+		}
+		invoke := result.Invokes[0]
+		if status := resultStatusNames[invoke.Status()]; status != test.status {
+			t.Errorf("%s: status %s, want %s", test.name, status, test.status)
+		}
+		attempts := make([]string, len(invoke.Attempts))
+		for i, attempt := range invoke.Attempts {
+			if attempt.Attempt != i+1 {
+				t.Errorf("%s: attempt %d is numbered %d", test.name, i+1, attempt.Attempt)
+			}
+			attempts[i] = "failed"
+			if attempt.Passed() {
+				attempts[i] = "passed"
+			}
+		}
+		if got := strings.Join(attempts, " "); got != test.attempts {
+			t.Errorf("%s: attempts %s, want %s", test.name, got, test.attempts)
+		}
+		if server.calls != len(invoke.Attempts) {
+			t.Errorf("%s: %d call(s) for %d attempt(s)", test.name, server.calls, len(invoke.Attempts))
+		}
+		if username := (*invoke.Invoke.Response)["username"]; username != test.username {
+			t.Errorf("%s: examined the response of %v, want %s", test.name, username, test.username)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// trpcError stops examining a file, an invoke or an expect, it is raised by
+// syntaxError and invalidParameter and recovered into the related result.
+type trpcError struct {
+	ExitCode int
+	Msg      string
+}
+
+func (e trpcError) Error() string {
+	return e.Msg
+}
+
+// recoveredError turns what a deferred recover() returned into an error.
+func recoveredError(recovered any) error {
+	switch err := recovered.(type) {
+	case nil:
+		return nil
+	case trpcError:
+		return err
+	case error:
+		return trpcError{ExitCode: 1, Msg: err.Error()}
+	default:
+		return trpcError{ExitCode: 1, Msg: fmt.Sprint(err)}
+	}
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if tErr, ok := err.(trpcError); ok {
+		return tErr.ExitCode
+	}
+	return 1
+}
+
+type ResultStatus = int
+
+// Result statuses from the best to the worst
+const (
+	ResultPassed ResultStatus = iota
+	ResultWarned
+	ResultSkipped
+	ResultFailed
+	ResultErrored
+)
+
+var resultStatusNames = map[ResultStatus]string{
+	ResultPassed:  "passed",
+	ResultWarned:  "warned",
+	ResultSkipped: "skipped",
+	ResultFailed:  "failed",
+	ResultErrored: "error",
+}
+
+type ExpectResult struct {
+	Expect *Expect
+	// Condition is nil when the expect passed
+	Condition *InvokeCondition
+	// Err is set when the expect could not be examined at all
+	Err error
+}
+
+type InvokeResult struct {
+	Invoke     *Invoke
+	Expects    []*ExpectResult
+	Err        error
+	SkipReason string
+	Duration   time.Duration
+	// Latency is the wall-clock time of the RPC of the examined call
+	Latency time.Duration
+	// Attempts are the calls of an invoke with retry, the last one is examined
+	Attempts []*AttemptResult
+}
+
+// AttemptResult is a call of an invoke with retry.
+type AttemptResult struct {
+	Attempt int
+	// Err is set when the RPC could not be called
+	Err error
+	// Failures tell why the expects deciding the attempt did not pass
+	Failures []string
+	Duration time.Duration
+}
+
+func (a *AttemptResult) Passed() bool {
+	return a.Err == nil && len(a.Failures) == 0
+}
+
+// Reason tells why the attempt did not pass.
+func (a *AttemptResult) Reason() string {
+	if a.Err != nil {
+		return a.Err.Error()
+	}
+	return strings.Join(a.Failures, "; ")
+}
+
+func (a *AttemptResult) String() string {
+	if a.Passed() {
+		return fmt.Sprintf("attempt %d passed in %v", a.Attempt, a.Duration.Round(time.Microsecond))
+	}
+	return fmt.Sprintf("attempt %d did not pass in %v: %s", a.Attempt, a.Duration.Round(time.Microsecond), a.Reason())
+}
+
+func (r *InvokeResult) Status() ResultStatus {
+	if r.Err != nil {
+		return ResultErrored
+	}
+	if r.SkipReason != "" {
+		return ResultSkipped
+	}
+	status := ResultPassed
+	for _, expect := range r.Expects {
+		if expect.Err != nil {
+			return ResultErrored
+		}
+		if expect.Condition == nil {
+			continue
+		}
+		if expect.Condition.Condition == InvokeFailed {
+			status = ResultFailed
+		} else if status == ResultPassed {
+			status = ResultWarned
+		}
+	}
+	return status
+}
+
+func (r *InvokeResult) ExitCode() int {
+	code := exitCodeOf(r.Err)
+	for _, expect := range r.Expects {
+		expectCode := exitCodeOf(expect.Err)
+		if expect.Condition != nil && expect.Condition.Condition == InvokeFailed {
+			expectCode = 3
+		}
+		if expectCode > code {
+			code = expectCode
+		}
+	}
+	return code
+}
+
+// TestResult is the outcome of running a .trpc file.
+type TestResult struct {
+	File    string
+	Entry   *Entry
+	Invokes []*InvokeResult
+	// Teardown holds the invokes of teardown blocks, they are reported apart
+	Teardown []*InvokeResult
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+}
+
+func (r *TestResult) ExitCode() int {
+	code := exitCodeOf(r.Err)
+	for _, invokes := range [][]*InvokeResult{r.Invokes, r.Teardown} {
+		for _, invoke := range invokes {
+			if invokeCode := invoke.ExitCode(); invokeCode > code {
+				code = invokeCode
+			}
+		}
+	}
+	return code
+}
+
+func (r *TestResult) Name() string {
+	if r.Entry != nil && r.Entry.TestName != "" {
+		return r.Entry.TestName
+	}
+	return r.File
+}
+
+// Counts returns the number of invokes per status.
+func (r *TestResult) Counts() map[ResultStatus]int {
+	return countStatuses(r.Invokes)
+}
+
+func countStatuses(invokes []*InvokeResult) map[ResultStatus]int {
+	counts := make(map[ResultStatus]int)
+	for _, invoke := range invokes {
+		counts[invoke.Status()]++
+	}
+	return counts
+}
+
+func (r *TestResult) PrintSummary() {
+	fmt.Println("===========================")
+	if r.Err != nil {
+		color.Red("Test \"%s\" (%s) could not run: %v", r.Name(), r.File, r.Err)
+	}
+	printInvokeResults(r.Invokes)
+	counts := r.Counts()
+	fmt.Printf("Test \"%s\": %d invoke(s), %d passed, %d failed, %d error(s), %d skipped in %v\n",
+		r.Name(), len(r.Invokes), counts[ResultPassed]+counts[ResultWarned], counts[ResultFailed], counts[ResultErrored], counts[ResultSkipped], r.Duration.Round(time.Millisecond))
+	if len(r.Teardown) > 0 {
+		fmt.Println("Teardown:")
+		printInvokeResults(r.Teardown)
+		counts := countStatuses(r.Teardown)
+		fmt.Printf("Teardown of \"%s\": %d invoke(s), %d passed, %d failed, %d error(s), %d skipped\n",
+			r.Name(), len(r.Teardown), counts[ResultPassed]+counts[ResultWarned], counts[ResultFailed], counts[ResultErrored], counts[ResultSkipped])
+	}
+	if r.Entry != nil && r.Entry.Warnings+r.Entry.Ignores > 0 {
+		fmt.Printf("Test done with %d warning(s) and %d ignoration(s) \n", r.Entry.Warnings, r.Entry.Ignores)
+	}
+	if r.ExitCode() == 0 {
+		println("✅ All tests passed as expected 😎")
+	}
+}
+
+func printInvokeResults(invokes []*InvokeResult) {
+	for _, invoke := range invokes {
+		name := invoke.Invoke.Name
+		if len(invoke.Attempts) > 1 {
+			name = fmt.Sprintf("%s (%d attempts)", name, len(invoke.Attempts))
+		}
+		switch invoke.Status() {
+		case ResultErrored:
+			err := invoke.Err
+			for _, expect := range invoke.Expects {
+				if err == nil {
+					err = expect.Err
+				}
+			}
+			color.Red("⛔ %s: %v", name, err)
+		case ResultFailed:
+			failures := 0
+			for _, expect := range invoke.Expects {
+				if expect.Condition != nil && expect.Condition.Condition == InvokeFailed {
+					failures++
+				}
+			}
+			color.Red("⛔ %s: %d of %d expect(s) failed", name, failures, len(invoke.Expects))
+		case ResultSkipped:
+			color.Yellow("⏭  %s: skipped, %s", name, invoke.SkipReason)
+		case ResultWarned:
+			color.HiYellow("❕ %s: passed with warnings", name)
+		default:
+			color.Green("✅ %s", name)
+		}
+	}
+}
+
+// PrintResults prints the aggregate of all files and returns the exit code of
+// the worst outcome.
+func PrintResults(results []*TestResult) int {
+	exitCode := 0
+	passed := 0
+	for _, result := range results {
+		code := result.ExitCode()
+		if code > exitCode {
+			exitCode = code
+		}
+		if code == 0 {
+			passed++
+		}
+	}
+	if len(results) > 1 {
+		fmt.Println("===========================")
+		for _, result := range results {
+			if result.ExitCode() == 0 {
+				color.Green("✅ %s (%s)", result.Name(), result.File)
+			} else {
+				color.Red("⛔ %s (%s)", result.Name(), result.File)
+			}
+		}
+		fmt.Printf("%d of %d test file(s) passed\n", passed, len(results))
+	}
+	return exitCode
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// captureOutput returns what fn prints to stdout, colored output included
+// without its colors.
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, output, noColor := os.Stdout, color.Output, color.NoColor
+	os.Stdout, color.Output, color.NoColor = writer, writer, true
+	defer func() {
+		os.Stdout, color.Output, color.NoColor = stdout, output, noColor
+	}()
+	printed := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(reader)
+		printed <- string(b)
+	}()
+	fn()
+	writer.Close()
+	return <-printed
+}
+
+func failedExpect(condition InvokeConditionStatus) *ExpectResult {
+	return &ExpectResult{Condition: &InvokeCondition{Condition: condition}}
+}
+
+func invokeResult(name string, expects ...*ExpectResult) *InvokeResult {
+	return &InvokeResult{Invoke: &Invoke{Name: name}, Expects: expects}
+}
+
+func TestExitCodeOf(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{trpcError{ExitCode: 2, Msg: "syntax"}, 2},
+		{trpcError{ExitCode: 3}, 3},
+		{errors.New("other"), 1},
+		{recoveredError("panic"), 1},
+		{recoveredError(trpcError{ExitCode: 2}), 2},
+	} {
+		if got := exitCodeOf(test.err); got != test.want {
+			t.Errorf("exitCodeOf(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestInvokeResultStatus(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		result *InvokeResult
+		status ResultStatus
+		code   int
+	}{
+		{"passed", invokeResult("a", &ExpectResult{}), ResultPassed, 0},
+		{"warned", invokeResult("a", failedExpect(InvokeDoneWithWarnings), failedExpect(InvokeDoneWithIgnores)), ResultWarned, 0},
+		{"failed", invokeResult("a", failedExpect(InvokeDoneWithWarnings), failedExpect(InvokeFailed)), ResultFailed, 3},
+		{"broken expect", invokeResult("a", failedExpect(InvokeFailed), &ExpectResult{Err: trpcError{ExitCode: 2}}), ResultErrored, 3},
+		{"syntax error", invokeResult("a", &ExpectResult{Err: trpcError{ExitCode: 2}}), ResultErrored, 2},
+		{"skipped", &InvokeResult{Invoke: &Invoke{Name: "a"}, SkipReason: "why"}, ResultSkipped, 0},
+		{"call error", &InvokeResult{Invoke: &Invoke{Name: "a"}, Err: errors.New("unavailable")}, ResultErrored, 1},
+	} {
+		if got := test.result.Status(); got != test.status {
+			t.Errorf("%s: Status() = %s, want %s", test.name, resultStatusNames[got], resultStatusNames[test.status])
+		}
+		if got := test.result.ExitCode(); got != test.code {
+			t.Errorf("%s: ExitCode() = %d, want %d", test.name, got, test.code)
+		}
+	}
+}
+
+func TestTestResultExitCode(t *testing.T) {
+	passed := &TestResult{File: "a.trpc", Invokes: []*InvokeResult{invokeResult("a", failedExpect(InvokeDoneWithWarnings))}}
+	failedTeardown := &TestResult{File: "b.trpc", Teardown: []*InvokeResult{invokeResult("clean", failedExpect(InvokeFailed))}}
+	errored := &TestResult{File: "c.trpc", Err: trpcError{ExitCode: 2, Msg: "bad"}}
+	for _, test := range []struct {
+		results []*TestResult
+		want    int
+	}{
+		{[]*TestResult{passed}, 0},
+		{[]*TestResult{passed, failedTeardown}, 3},
+		{[]*TestResult{errored, passed}, 2},
+		{[]*TestResult{errored, failedTeardown}, 3},
+	} {
+		var got int
+		captureOutput(t, func() { got = PrintResults(test.results) })
+		if got != test.want {
+			t.Errorf("PrintResults of %d file(s) = %d, want %d", len(test.results), got, test.want)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	retried := invokeResult("poll", failedExpect(InvokeDoneWithWarnings))
+	retried.Attempts = []*AttemptResult{{Attempt: 1}, {Attempt: 2}}
+	result := &TestResult{
+		File:  "a.trpc",
+		Entry: &Entry{TestName: "summary"},
+		Invokes: []*InvokeResult{
+			invokeResult("ok", &ExpectResult{}),
+			retried,
+			invokeResult("bad", failedExpect(InvokeFailed), &ExpectResult{}),
+			{Invoke: &Invoke{Name: "after"}, SkipReason: "referenced invoke bad did not pass"},
+			{Invoke: &Invoke{Name: "down"}, Err: errors.New("unavailable")},
+		},
+		Teardown: []*InvokeResult{invokeResult("clean", &ExpectResult{})},
+	}
+	printed := captureOutput(t, result.PrintSummary)
+	for _, want := range []string{
+		"✅ ok\n",
+		"❕ poll (2 attempts): passed with warnings\n",
+		"⛔ bad: 1 of 2 expect(s) failed\n",
+		"⏭  after: skipped, referenced invoke bad did not pass\n",
+		"⛔ down: unavailable\n",
+		`Test "summary": 5 invoke(s), 2 passed, 1 failed, 1 error(s), 1 skipped in 0s`,
+		"Teardown:\n✅ clean\n",
+		`Teardown of "summary": 1 invoke(s), 1 passed, 0 failed, 0 error(s), 0 skipped`,
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("summary does not contain %q:\n%s", want, printed)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 
@@ -14,6 +17,7 @@ import (
 	"trpc/trpc_marshal"
 
 	"github.com/alecthomas/participle/v2/lexer"
+	"google.golang.org/grpc/metadata"
 	//"bytes"
 	//"github.com/fullstorydev/grpcurl"
 	//"github.com/golang/protobuf/jsonpb"
@@ -22,294 +26,761 @@ import (
 
 const acceptableVersion = "v0.0.1"
 
-func TasteAndRun(trpc *Trpc) {
-	verbose := 0
-	_maxTime := 0.0
-	maxTime := &_maxTime
-	_timeOut := 0.0
-	connectionTimeout := &_timeOut
+// testRun holds what a .trpc file declares and the state of running it.
+type testRun struct {
+	mainEntery        *Entry
+	verbose           int
+	maxTime           float64
+	connectionTimeout float64
 
 	//keepalive := &_timeOut
-	protoImportPaths := make([]string, 0)
-	protoFiles := make([]string, 0)
-	protoSets := make([]string, 0)
-	namedEndpointes := make(map[string]Endpoint, 0)
-
-	invokeOrder := make([]string, 0)
-	namedInvokes := make(NamedInvokes, 0)
-
-	defaultFailBehave := "Panic"
+	protoImportPaths []string
+	protoFiles       []string
+	protoSets        []string
+	namedEndpointes  map[string]Endpoint
+	variables        Variables
+	profile          *profileOverrides
+
+	invokeOrder []string
+	// setupOrder and teardownOrder are the invokes of setup and teardown blocks
+	setupOrder    []string
+	teardownOrder []string
+	namedInvokes  NamedInvokes
+
+	namedInvokeHandlers map[string]grpcrunner.TRPCHandler
+	invokeResults       map[string]*InvokeResult
+	// session shares connections and descriptor sources between invokes
+	session *grpcrunner.Session
+	// mu guards invokeResults and namedInvokeHandlers when invokes run in parallel
+	mu sync.Mutex
+}
 
-	//expects := make([]Expect, 0)
+func TasteAndRun(trpc *Trpc) (result *TestResult) {
+	mainEntery := trpc.Entries[0]
+	result = &TestResult{
+		File:  mainEntery.Pos.Filename,
+		Entry: mainEntery,
+		Start: time.Now(),
+	}
+	defer func() {
+		result.Err = recoveredError(recover())
+		result.Duration = time.Since(result.Start)
+		result.PrintSummary()
+	}()
+
+	run := newTestRun(mainEntery)
+	run.session = grpcrunner.NewSession()
+	defer run.session.Close()
+	run.load(trpc, cli.Run.Profile)
+
+	// teardown runs whatever the outcome of setup and the invokes
+	defer func() {
+		result.Teardown = run.runInvokes(run.teardownOrder, 1)
+	}()
+	result.Invokes = run.runInvokes(run.setupOrder, 1)
+	if failed := failedInvoke(result.Invokes); failed != "" {
+		for _, invokeName := range run.invokeOrder {
+			result.Invokes = append(result.Invokes, run.skipInvoke(run.namedInvokes[invokeName], fmt.Sprintf("setup invoke %s did not pass", failed)))
+		}
+		return result
+	}
+	result.Invokes = append(result.Invokes, run.runInvokes(run.invokeOrder, cli.Run.Parallel)...)
+	return result
+}
 
-	namedInvokeHandlers := make(map[string]grpcrunner.TRPCHandler, 0)
+func newTestRun(mainEntery *Entry) *testRun {
+	return &testRun{
+		mainEntery:          mainEntery,
+		protoImportPaths:    make([]string, 0),
+		protoFiles:          make([]string, 0),
+		protoSets:           make([]string, 0),
+		namedEndpointes:     make(map[string]Endpoint, 0),
+		variables:           make(Variables, 0),
+		invokeOrder:         make([]string, 0),
+		namedInvokes:        make(NamedInvokes, 0),
+		namedInvokeHandlers: make(map[string]grpcrunner.TRPCHandler, 0),
+		invokeResults:       make(map[string]*InvokeResult, 0),
+	}
+}
 
-	mainEntery := trpc.Entries[0]
+// load checks what the file declares, with its includes and the entries of
+// the profile, and queues its invokes without calling any of them.
+func (run *testRun) load(trpc *Trpc, profile string) {
+	mainEntery := run.mainEntery
 	if len(mainEntery.TestName) == 0 {
-		print("Be kind and name your test")
-		return
+		panic(trpcError{ExitCode: 2, Msg: "Be kind and name your test"})
 	} else {
-		if mainEntery.MaxTime > 0 {
-			maxTime = &mainEntery.MaxTime
-		}
-		if mainEntery.Timeout > 0 {
-			connectionTimeout = &mainEntery.Timeout
-		}
-		verbose = mainEntery.VerboseLevel
+		mainEntery.TestName, _ = strconv.Unquote(mainEntery.TestName)
+		mainEntery.Description, _ = strconv.Unquote(mainEntery.Description)
+		run.maxTime = mainEntery.MaxTime
+		run.connectionTimeout = mainEntery.Timeout
+		run.verbose = mainEntery.VerboseLevel
+	}
+
+	mainFile, _ := filepath.Abs(mainEntery.Pos.Filename)
+	entries := run.includeEntries(trpc.Entries[1:], []string{mainFile}, map[string]bool{mainFile: true})
+	trpc = &Trpc{Pos: trpc.Pos, Entries: append([]*Entry{mainEntery}, entries...)}
+
+	if profile != "" {
+		run.loadProfile(trpc, profile)
 	}
 
+	teardowns := make([]*Phase, 0)
 	for _, entery := range trpc.Entries[1:] {
 		if entery.Description != "" {
 		}
 		if len(entery.ImportPath) > 0 {
 			iPath, _ := strconv.Unquote(entery.ImportPath)
-			protoImportPaths = append(protoImportPaths, iPath)
+			run.protoImportPaths = append(run.protoImportPaths, iPath)
 		}
 		if len(entery.ImportProto) > 0 {
 			pFile, _ := strconv.Unquote(entery.ImportProto)
-			protoFiles = append(protoFiles, pFile)
+			run.protoFiles = append(run.protoFiles, pFile)
 		}
 		if len(entery.ImportProtoSet) > 0 {
 			pSet, _ := strconv.Unquote(entery.ImportProtoSet)
-			protoSets = append(protoSets, pSet)
+			run.protoSets = append(run.protoSets, pSet)
+		}
+
+		if entery.Let != nil && (run.profile == nil || !run.profile.variables[entery.Let.Name]) {
+			if _, exists := run.variables[entery.Let.Name]; exists {
+				syntaxError(mainEntery.Lines, entery.Pos, 0, "Duplicate variable %s", entery.Let.Name)
+			}
+			value, haveReference := entery.Let.Value.value(mainEntery.Lines, &run.namedInvokes, run.variables, false)
+			if haveReference {
+				syntaxError(mainEntery.Lines, entery.Let.Value.Pos, 0, "Variable %s can not reference invokes", entery.Let.Name)
+			}
+			run.variables[entery.Let.Name] = value
 		}
 
 		if entery.Endpoint != nil {
-			unQuoted, _ := strconv.Unquote(entery.Endpoint.IPDomain)
-			entery.Endpoint.IPDomain = unQuoted
-			namedEndpointes[entery.Endpoint.Name] = *entery.Endpoint
+			run.namedEndpointes[entery.Endpoint.Name] = *run.profileEndpoint(entery.Endpoint)
 		}
 
 		if entery.Invoke != nil {
-			if existInvoke, exists := namedInvokes[entery.Invoke.Name]; exists {
-				fmt.Printf("Duplicate invoke name %s in %s:%d which defined at %s:%d\n", entery.Invoke.Name, entery.Pos.Filename, entery.Pos.Line, existInvoke.Pos.Filename, existInvoke.Pos.Line)
-				return
-			} else {
-				//jb, _ := json.MarshalIndent(entery.Invoke.ParsedData, "", "  ")
-				//println(entery.Invoke.Name, "  ", string(jb), entery.Invoke.ParsedData, entery.Invoke.Headers)
-				namedInvokes[entery.Invoke.Name] = entery.Invoke
-				entery.Invoke.Parse((*mainEntery).Lines, &namedInvokes, false, true)
-				entery.Invoke.Response = nil
-				entery.Invoke.Goal, _ = strconv.Unquote(entery.Invoke.Goal)
-				invokeOrder = append(invokeOrder, entery.Invoke.Name)
-				entery.Invoke.Entry = mainEntery
-				entery.Invoke.Conditions = make([]InvokeCondition, 0)
-				for _, expect := range entery.Invoke.SourceExpects {
-					//expect.Reference. = entery.Invoke.Name + "." + expect.Reference
-					//expect.Code = strings.Split(expect.Reference, ".")
-					expect.Invoke = entery.Invoke
-					if expect.OnFail == nil {
-						expect.OnFail = &defaultFailBehave
-					} else if *expect.OnFail != "Warn" && *expect.OnFail != "Ignore" {
-						syntaxError(mainEntery.Lines, expect.Pos, 0, "Invalid onFail param", *expect.OnFail)
-					}
-					entery.Invoke.Expects = append(entery.Invoke.Expects, *expect)
+			run.addInvokes(entery.Invoke, &run.invokeOrder)
+		}
+		if entery.Setup != nil {
+			for _, invoke := range entery.Setup.Invokes {
+				run.addInvokes(invoke, &run.setupOrder)
+			}
+		}
+		if entery.Teardown != nil {
+			teardowns = append(teardowns, entery.Teardown)
+		}
+	}
+	// teardown may reference any invoke of the file wherever it is declared
+	for _, teardown := range teardowns {
+		for _, invoke := range teardown.Invokes {
+			run.addInvokes(invoke, &run.teardownOrder)
+		}
+	}
+}
+
+// addInvokes queues the invoke to run in order, once per row of its cases.
+func (run *testRun) addInvokes(invoke *Invoke, order *[]string) {
+	if invoke.Cases == nil {
+		run.addInvoke(invoke, order)
+		return
+	}
+	for _, caseInvoke := range run.caseInvokes(invoke) {
+		run.addInvoke(caseInvoke, order)
+	}
+}
+
+// failedInvoke returns the name of the first invoke which did not pass.
+func failedInvoke(results []*InvokeResult) string {
+	for _, result := range results {
+		if status := result.Status(); status != ResultPassed && status != ResultWarned {
+			return result.Invoke.Name
+		}
+	}
+	return ""
+}
+
+// skipInvoke reports the invoke as skipped without running it.
+func (run *testRun) skipInvoke(invoke *Invoke, reason string) *InvokeResult {
+	color.Yellow("Invoke %s skipped, %s", invoke.Name, reason)
+	invokeResult := &InvokeResult{Invoke: invoke, SkipReason: reason}
+	run.invokeResults[invoke.Name] = invokeResult
+	return invokeResult
+}
+
+// addInvoke checks the invoke and its expects and queues it to run in order.
+func (run *testRun) addInvoke(invoke *Invoke, order *[]string) {
+	mainEntery := run.mainEntery
+	defaultFailBehave := "Panic"
+	if existInvoke, exists := run.namedInvokes[invoke.Name]; exists {
+		syntaxError(mainEntery.Lines, invoke.Pos, 0, "Duplicate invoke name %s which defined at %s:%d", invoke.Name, existInvoke.Pos.Filename, existInvoke.Pos.Line)
+	} else {
+		//jb, _ := json.MarshalIndent(invoke.ParsedData, "", "  ")
+		//println(invoke.Name, "  ", string(jb), invoke.ParsedData, invoke.Headers)
+		if _, exists := run.variables[invoke.Name]; exists {
+			syntaxError(mainEntery.Lines, invoke.Pos, 0, "Invoke %s has the name of a variable", invoke.Name)
+		}
+		run.namedInvokes[invoke.Name] = invoke
+		if invoke.Variables == nil {
+			invoke.Variables = run.variables
+		}
+		invoke.Parse(mainEntery.Lines, &run.namedInvokes, false, true)
+		invoke.Response = nil
+		invoke.Goal, _ = strconv.Unquote(invoke.Goal)
+		*order = append(*order, invoke.Name)
+		invoke.Entry = mainEntery
+		invoke.Conditions = make([]InvokeCondition, 0)
+		sourceExpects := invoke.SourceExpects
+		run.checkTimeouts(invoke)
+		if invoke.Retry != nil {
+			run.checkRetry(invoke)
+			// until expects are examined on the last attempt too
+			sourceExpects = append(append([]*Expect{}, invoke.Retry.Until...), sourceExpects...)
+		}
+		for _, expect := range sourceExpects {
+			//expect.Reference. = invoke.Name + "." + expect.Reference
+			//expect.Code = strings.Split(expect.Reference, ".")
+			expect.Invoke = invoke
+			if expect.Quantifier != nil && expect.Path.Parts[0].Obj != "responses" && !expect.Path.Multi() {
+				syntaxError(mainEntery.Lines, expect.Pos, 0, "\"%s\" can only be used on responses or a path matching many values", *expect.Quantifier)
+			}
+			if expect.OnFail == nil {
+				expect.OnFail = &defaultFailBehave
+			} else if *expect.OnFail != "Warn" && *expect.OnFail != "Ignore" {
+				syntaxError(mainEntery.Lines, expect.Pos, 0, "Invalid onFail param %s", *expect.OnFail)
+			}
+			invoke.Expects = append(invoke.Expects, *expect)
+		}
+		if invoke.Data != nil && invoke.RequestStream != nil {
+			syntaxError(mainEntery.Lines, invoke.Pos, 0, "Invoke %s can not have both data and stream/exchange", invoke.Name)
+		}
+		if invoke.Stream != nil && invoke.Exchange != nil {
+			syntaxError(mainEntery.Lines, invoke.Pos, 0, "Invoke %s can not have both stream and exchange", invoke.Name)
+		}
+		for i, step := range invoke.Exchange {
+			for _, expect := range step.Expects {
+				if expect.Quantifier != nil || expect.Path.Parts[0].Obj != "response" {
+					syntaxError(mainEntery.Lines, expect.Pos, 0, "Exchange expects must examine the reply, response.*")
+				}
+				// response.field of the step is the reply to its message, responses[i].field
+				index := Index(i)
+				expect.Path.Parts[0] = Part{Obj: "responses", Acc: []Acc{{IntIndex: &index}}}
+				expect.Invoke = invoke
+				if expect.OnFail == nil {
+					expect.OnFail = &defaultFailBehave
 				}
+				invoke.Expects = append(invoke.Expects, *expect)
 			}
 		}
 	}
+}
+
+// runInvokes runs the invokes in order, with parallel above 1 an invoke
+// starts as soon as the earlier invokes it references are done and at most
+// parallel invokes run at once.
+func (run *testRun) runInvokes(order []string, parallel int) []*InvokeResult {
+	results := make([]*InvokeResult, len(order))
+	if parallel <= 1 {
+		for i, invokeName := range order {
+			results[i] = run.runInvoke(run.namedInvokes[invokeName])
+			run.invokeResults[invokeName] = results[i]
+		}
+		return results
+	}
+
+	done := make(map[string]chan struct{}, len(order))
+	position := make(map[string]int, len(order))
+	for i, invokeName := range order {
+		done[invokeName] = make(chan struct{})
+		position[invokeName] = i
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, invokeName := range order {
+		// only earlier invokes are dependencies, as when running in order
+		var dependencies []chan struct{}
+		for _, name := range run.namedInvokes[invokeName].References() {
+			if j, ok := position[name]; ok && j < i {
+				dependencies = append(dependencies, done[name])
+			}
+		}
+		wg.Add(1)
+		go func(i int, invokeName string) {
+			defer wg.Done()
+			defer close(done[invokeName])
+			for _, dependency := range dependencies {
+				<-dependency
+			}
+			slots <- struct{}{}
+			invokeResult := run.runInvoke(run.namedInvokes[invokeName])
+			<-slots
+			run.mu.Lock()
+			run.invokeResults[invokeName] = invokeResult
+			run.mu.Unlock()
+			results[i] = invokeResult
+		}(i, invokeName)
+	}
+	wg.Wait()
+	return results
+}
+
+// resolveEndpoint evaluates the host and port of the endpoint, they may be
+// variables, env() lookups or interpolated strings.
+func (run *testRun) resolveEndpoint(endpoint *Endpoint) {
+	lines := run.mainEntery.Lines
+	host, haveReference := endpoint.Host.value(lines, &run.namedInvokes, run.variables, false)
+	if hostStr, ok := host.(string); ok && !haveReference {
+		endpoint.IPDomain = hostStr
+	} else {
+		syntaxError(lines, endpoint.Host.Pos, 0, "Endpoint %s host must be a string but got %v", endpoint.Name, host)
+	}
+	port, haveReference := endpoint.PortValue.value(lines, &run.namedInvokes, run.variables, false)
+	switch portValue := port.(type) {
+	case int64:
+		endpoint.Port = int(portValue)
+	case string:
+		if portNumber, err := strconv.Atoi(portValue); err == nil {
+			endpoint.Port = portNumber
+		} else {
+			syntaxError(lines, endpoint.PortValue.Pos, 0, "Endpoint %s port must be a number but got \"%s\"", endpoint.Name, portValue)
+		}
+	default:
+		syntaxError(lines, endpoint.PortValue.Pos, 0, "Endpoint %s port must be a number but got %v", endpoint.Name, port)
+	}
+	if haveReference {
+		syntaxError(lines, endpoint.PortValue.Pos, 0, "Endpoint %s port can not reference invokes", endpoint.Name)
+	}
+
+	endpoint.CACert = run.endpointString(endpoint, "cacert", endpoint.CACertValue)
+	endpoint.Cert = run.endpointString(endpoint, "cert", endpoint.CertValue)
+	endpoint.Key = run.endpointString(endpoint, "key", endpoint.KeyValue)
+	endpoint.Authority = run.endpointString(endpoint, "authority", endpoint.AuthorityValue)
+	endpoint.ServerName = run.endpointString(endpoint, "serverName", endpoint.ServerNameValue)
+	if impliesTls(endpoint.CACert, endpoint.Cert, endpoint.Insecure, endpoint.ServerName) {
+		endpoint.Tls = true
+	}
+}
 
-	for _, invokeName := range invokeOrder {
-		invoke := namedInvokes[invokeName]
+// impliesTls tells whether the TLS options of an endpoint ask for tls, every
+// option but authority does.
+func impliesTls(caCert, cert string, insecure bool, serverName string) bool {
+	return caCert != "" || cert != "" || insecure || serverName != ""
+}
+
+// endpointString evaluates an optional string option of the endpoint.
+func (run *testRun) endpointString(endpoint *Endpoint, option string, val *Value) string {
+	if val == nil {
+		return ""
+	}
+	lines := run.mainEntery.Lines
+	str, haveReference := val.value(lines, &run.namedInvokes, run.variables, false)
+	if haveReference {
+		syntaxError(lines, val.Pos, 0, "Endpoint %s %s can not reference invokes", endpoint.Name, option)
+	}
+	if _, ok := str.(string); !ok {
+		syntaxError(lines, val.Pos, 0, "Endpoint %s %s must be a string but got %v", endpoint.Name, option, str)
+	}
+	return str.(string)
+}
 
-		fmt.Println("===========================\ninvoke: ", invokeName)
-		if endPoint, ok := namedEndpointes[invoke.EndPoint]; ok {
-			//fmt.Printf("call %s:%d%s/%s/%s %v\n", endPoint.IPDomain, endPoint.Port, endPoint.PerfixPath, invoke.Service, invoke.RPC, invoke.ContaineReferences)
-			if invoke.ContaineReferences {
-				invoke.Parse((*mainEntery).Lines, &namedInvokes, true, false)
+// failedReference returns the name of an invoke which this invoke references
+// and did not pass, the values this invoke needs from it can not be trusted.
+func (run *testRun) failedReference(invoke *Invoke) string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for _, name := range invoke.References() {
+		if referenced, ok := run.invokeResults[name]; ok {
+			if status := referenced.Status(); status != ResultPassed && status != ResultWarned {
+				return name
 			}
+		}
+	}
+	return ""
+}
+
+// runInvoke calls the RPC of the invoke and examines all of its expects, a
+// failing expect does not stop the next ones.
+func (run *testRun) runInvoke(invoke *Invoke) (invokeResult *InvokeResult) {
+	invokeName := invoke.Name
+	mainEntery := run.mainEntery
+	invokeResult = &InvokeResult{Invoke: invoke}
+	start := time.Now()
+	defer func() {
+		if err := recoveredError(recover()); err != nil {
+			invokeResult.Err = err
+		}
+		invokeResult.Duration = time.Since(start)
+	}()
+
+	fmt.Println("===========================\ninvoke: ", invokeName)
+	if failed := run.failedReference(invoke); failed != "" {
+		invokeResult.SkipReason = fmt.Sprintf("referenced invoke %s did not pass", failed)
+		color.Yellow("Invoke %s skipped, referenced invoke %s did not pass", invokeName, failed)
+		return invokeResult
+	}
+	if endPoint, ok := run.namedEndpointes[invoke.EndPoint]; ok {
+		handler, err := run.callUntil(invoke, endPoint, invokeResult)
+		if err != nil {
+			color.Red("Invoke %s failed: %v", invokeName, err)
+			invokeResult.Err = err
+			return invokeResult
+		}
+		invokeResult.Latency = handler.Latency
+
+		checkExchange(mainEntery, invoke, handler)
+		for i := range invoke.Conditions {
+			invokeResult.Expects = append(invokeResult.Expects, &ExpectResult{Expect: invoke.Conditions[i].Expect, Condition: &invoke.Conditions[i]})
+		}
+
+		for i := range invoke.Expects {
+			invokeResult.Expects = append(invokeResult.Expects, run.checkExpect(invoke, handler, &invoke.Expects[i]))
+		}
+	} else {
+		offset := len("invoke " + invokeName + " ")
+		knownEndPoints := make([]string, 0)
+		for k := range run.namedEndpointes {
+			knownEndPoints = append(knownEndPoints, k)
+		}
+		invalidParameter((*mainEntery).Lines, invoke.Pos, offset, "Endpoint \"%s\" not found for Invoke \"%s\"\nKnown endpoints:\n%s\n", invoke.EndPoint, invokeName, strings.Join(knownEndPoints, "\n"))
+	}
+	return invokeResult
+}
+
+// endpointParams are the params of calls to the endpoint, with the imports
+// of the file.
+func (run *testRun) endpointParams(endPoint Endpoint) grpcrunner.RunParams {
+	return grpcrunner.RunParams{
+		ProtoFiles:  run.protoFiles,
+		ImportPaths: run.protoImportPaths,
+		Protoset:    run.protoSets,
+		Target:      fmt.Sprintf("%s:%d", endPoint.IPDomain, endPoint.Port),
+		PrefixPath:  endPoint.PerfixPath,
+		// ReflectionPrefixPath: endPoint.ReflectionPerfixPath,
+		Plaintext:  !endPoint.Tls,
+		Insecure:   endPoint.Insecure,
+		CACert:     endPoint.CACert,
+		Cert:       endPoint.Cert,
+		Key:        endPoint.Key,
+		Authority:  endPoint.Authority,
+		ServerName: endPoint.ServerName,
+	}
+}
+
+// call calls the RPC of the invoke once and keeps its response on the invoke.
+func (run *testRun) call(invoke *Invoke, endPoint Endpoint) (*grpcrunner.TRPCHandler, error) {
+	if invoke.ContaineReferences {
+		invoke.Parse(run.mainEntery.Lines, &run.namedInvokes, true, false)
+	}
 
-			handler, _ := grpcrunner.Run(grpcrunner.RunParams{
-				ProtoFiles:  protoFiles,
-				ImportPaths: protoImportPaths,
-				Protoset:    protoSets,
-				Target:      fmt.Sprintf("%s:%d", endPoint.IPDomain, endPoint.Port),
-				PrefixPath:  endPoint.PerfixPath,
-				// ReflectionPrefixPath: endPoint.ReflectionPerfixPath,
-				Plaintext:      !endPoint.Tls,
-				Data:           invoke.RequestData,
-				RPCHeaders:     invoke.RequestHeaders,
-				ServiceName:    invoke.Service,
-				MethodName:     invoke.RPC,
-				Verbose:        verbose <= 2,
-				VeryVerbose:    verbose == 2,
-				MaxTime:        *maxTime,
-				KeepaliveTime:  0.0,
-				ConnectTimeout: *connectionTimeout,
-			})
-			//if err != nil {
-			//	if false {
-			//		fmt.Println(err)
-			//	}
-			//}
-			namedInvokeHandlers[invokeName] = *handler
-			//var message protoiface.MessageV1
-			if len(handler.ResponseData) > 0 {
-				//message = handler.ResponseData[0]
-				//response := make(map[string]interface{})
-
-				//jsonB, jErr := marshal(message, handler.Descriptor)
-				//if jErr != nil {
-				//	println("error on marshal message: ", jErr)
-				//} else {
-				//	println("message json: ", string(jsonB))
-				//}
-				//jErr = json.Unmarshal(jsonB, &response)
-				//invoke.ResponseJson = string(jsonB)
-				//invoke.Response = &response
-				//fmt.Printf("MD: %v\n------------------------------------\n", handler.MethodDescriptor)
-				////mdReq := handler.MethodDescriptor.GetInputType()
-				//mdRes := handler.MethodDescriptor.GetOutputType()
-				////fmt.Printf("MdReq: %s: %v\n------------------------------------\n", mdReq.GetFullyQualifiedName(), mdReq)
-				//fmt.Printf("MdRes: %s: %v\n------------------------------------\n", mdRes.GetFullyQualifiedName(), mdRes)
-				////refl := proto.MessageReflect(message)
-				//refl2, _ := dynamic.AsDynamicMessage(message)
-				//for _, fld := range mdRes.GetFields() {
-				//	if fld.IsRepeated() {
-				//		repeated := refl2.GetField(fld).([]interface{})
-				//		fmt.Printf("fld is repeated (%T) with len: %d ~= %d\n", refl2.GetField(fld), len(repeated), refl2.FieldLength(fld))
-				//		for i, v := range repeated {
-				//			fmt.Printf("fld(rp) %v[%d] =>(%T) %v\n", fld, i, v, v.(*dynamic.Message))
-				//		}
-				//	} else {
-				//		fmt.Printf("fld => %v: %v\n", fld, refl2.GetField(fld))
-				//	}
-				//}
-				m := trpc_marshal.RPCMessageToMap(*handler)
-				//fmt.Printf("----------------------------------===\nmarshaled : %v\n", m)
-				jb, _ := json.MarshalIndent(m, "", "  ")
-				//fmt.Println("----------====------------\n" + string(jb))
-				invoke.Response = &m
-				invoke.ResponseJson = string(jb)
-
-				//md, err := desc.LoadMessageDescriptorForMessage(message)
-				//namedFields := make(map[string]*desc.FieldDescriptor)
-				//var fields []*desc.FieldDescriptor
-				//if err != nil {
-				//	println("error: ", err)
-				//} else {
-				//	//println("message desc: ", md.String())
-				//	fields = md.GetFields()
-				//	for _, field := range fields {
-				//		//println("field: ", field.String())
-				//		namedFields[field.GetName()] = field
-				//		if _, exists := response[field.GetName()]; !exists {
-				//			response[field.GetName()] = nil
-				//		}
-				//	}
-				//}
-				//invoke.NamedFields = namedFields
-			} else {
-				invoke.Response = &map[string]interface{}{}
-				invoke.ResponseJson = "{}"
+	connectTimeout, maxTime := run.timeouts(invoke)
+	params := run.endpointParams(endPoint)
+	params.Data = invoke.RequestData
+	params.Stream = invoke.RequestStream
+	params.Lockstep = invoke.Exchange != nil
+	params.Session = run.session
+	params.Fresh = invoke.Fresh
+	params.RPCHeaders = invoke.RequestHeaders
+	params.ServiceName = invoke.Service
+	params.MethodName = invoke.RPC
+	params.Verbose = run.verbose <= 2
+	params.VeryVerbose = run.verbose == 2
+	params.MaxTime = maxTime
+	params.KeepaliveTime = 0.0
+	params.ConnectTimeout = connectTimeout
+	handler, err := grpcrunner.Run(params)
+	if err != nil {
+		return nil, err
+	}
+	run.mu.Lock()
+	run.namedInvokeHandlers[invoke.Name] = *handler
+	run.mu.Unlock()
+	if len(handler.ResponseData) > 0 {
+		messages := trpc_marshal.RPCMessagesToMaps(*handler)
+		m := messages[0]
+		jb, _ := json.MarshalIndent(m, "", "  ")
+		invoke.Response = &m
+		invoke.ResponseJson = string(jb)
+		invoke.Responses = messages
+		jb, _ = json.MarshalIndent(messages, "", "  ")
+		invoke.ResponsesJson = string(jb)
+	} else {
+		invoke.Response = &map[string]interface{}{}
+		invoke.ResponseJson = "{}"
+		invoke.Responses = []map[string]any{}
+		invoke.ResponsesJson = "[]"
+	}
+
+	invoke.ResponseHeaders = metadataToMap(handler.ResponseHeaders)
+	invoke.ResponseTrailers = metadataToMap(handler.Trailers)
+	invoke.Details = trpc_marshal.StatusDetailsToMaps(*handler)
+	jb, _ := json.MarshalIndent(invoke.Details, "", "  ")
+	invoke.DetailsJson = string(jb)
+	return handler, nil
+}
+
+// checkExpect examines an expect of the invoke, a failure is recorded as a
+// condition of the invoke and a broken expect only stops itself.
+func (run *testRun) checkExpect(invoke *Invoke, handler *grpcrunner.TRPCHandler, expect *Expect) (expectResult *ExpectResult) {
+	mainEntery := run.mainEntery
+	invokeName := invoke.Name
+	expectResult = &ExpectResult{Expect: expect}
+	conditions := len(invoke.Conditions)
+	defer func() {
+		expectResult.Err = recoveredError(recover())
+		if len(invoke.Conditions) > conditions {
+			expectResult.Condition = &invoke.Conditions[len(invoke.Conditions)-1]
+		}
+	}()
+
+	code := expect.Path.Parts
+
+	if code[0].Obj == "code" {
+		//fmt.Printf("code !? : <<%v>>\n", handler.Status)
+		codeFn, err := functions.CodeFunction(expect.Function.Name)
+		if err != nil {
+			syntaxError((*mainEntery).Lines, invoke.Pos, 0, err.Error())
+		}
+		fnErr := codeFn(handler.Status.Code())
+		if fnErr != nil {
+			testFailed(mainEntery, invoke, expect, 0, fnErr.Error())
+		}
+	} else if code[0].Obj == "message" {
+		args := functionArgs(mainEntery.Lines, &run.namedInvokes, expect)
+		if fn, err := functions.MessageFunction(expect.Function.Name); err == nil {
+			expected := ""
+			if len(args) > 0 {
+				expected, _ = args[0].(string)
 			}
+			if fnErr := fn(handler.Status.Message(), expected); fnErr != nil {
+				testFailed(mainEntery, invoke, expect, 0, "%s", fnErr.Error())
+			}
+		} else if fnErr := checkResponseValue(mainEntery.Lines, &run.namedInvokes, expect, handler.Status.Message()); fnErr != nil {
+			testFailed(mainEntery, invoke, expect, 0, "%s", fnErr.Error())
+		}
+	} else if code[0].Obj == "response" {
+		if invoke.Response == nil {
+			testFailed(mainEntery, invoke, expect, 0, "Expect check value on invocation with error response")
+			return expectResult
+		}
 
-			for _, expect := range invoke.Expects {
-				code := expect.Path.Parts
-
-				if code[0].Obj == "code" {
-					//fmt.Printf("code !? : <<%v>>\n", handler.Status)
-					codeFn, err := functions.CodeFunction(expect.Function.Name)
-					if err != nil {
-						syntaxError((*mainEntery).Lines, invoke.Pos, 0, err.Error())
-					}
-					fnErr := codeFn(handler.Status.Code())
-					if fnErr != nil {
-						testFailed(mainEntery, invoke, &expect, 0, fnErr.Error())
-					}
-				} else if code[0].Obj == "message" {
-					fn, err := functions.MessageFunction(expect.Function.Name)
-					if err != nil {
-						syntaxError((*mainEntery).Lines, invoke.Pos, 0, err.Error())
-					}
-					value, _ := expect.Function.Arg.value((*mainEntery).Lines, &namedInvokes, true)
-					fnErr := fn(value.(string), handler.Status.Message())
-					if fnErr != nil {
-						testFailed(mainEntery, invoke, &expect, 0, fnErr.Error())
-					}
-				} else if code[0].Obj == "response" {
-					if invoke.Response == nil {
-						testFailed(mainEntery, invoke, &expect, 0, "Expect check value on invocation with error response")
-					}
-
-					if len(code) == 1 {
-						if expect.Function.Name == "isEmpty" {
-							if len(*invoke.Response) != 0 {
-								testFailed(mainEntery, invoke, &expect, 0, "Response expected to be empty but it is not")
-							}
-						} else {
-							syntaxError((*mainEntery).Lines, invoke.Pos, 0, "Unknown function %v", expect.Function.Name)
-						}
-					}
-					code := code[1:]
-					//fmt.Printf(" %s expect %v\n", invokeName, code)
-					offset := len("expect " + invokeName + "." + "response.")
-					if val, exists := (*invoke.Response)[code[0].Obj]; exists {
-						parts := []Part{
-							{
-								Obj: invokeName,
-							},
-						}
-						expect.Path.Parts = append(parts, expect.Path.Parts...)
-						val, _ = Value{Reference: expect.Path}.value((*mainEntery).Lines, &namedInvokes, true)
-						switch expect.Function.Name {
-						case "hasValue":
-							{
-								if val == nil {
-									println("Test failed: ", invokeName, " expected data is not null but got null")
-								}
-							}
-						case "isNull":
-							{
-								if val != nil {
-									testFailed(mainEntery, invoke, &expect, offset, "%s expected to be null but got (%v) %T\nActual response:\n%s", expect.Path.String(), val, val, invoke.ResponseJson)
-								}
-							}
-						case "isEqual":
-							{
-								expectValue, _ := expect.Function.Arg.value((*mainEntery).Lines, &namedInvokes, true)
-								if val != expectValue {
-									testFailed(mainEntery, invoke, &expect, offset, "%s expected to be \"%v\" but got \"%v\"\n\nActual response:\n%s", expect.Path.String(), expectValue, val, invoke.ResponseJson)
-								}
-							}
-						case "isNotEmpty":
-							{
-								if val == nil {
-									println("Test failed: ", invokeName, " expected data is not empty but got null")
-								} else if val == "" {
-									println("Test failed: ", invokeName, " expected data is not empty but got empty string")
-								}
-							}
-						default:
-							fmt.Printf("Test failed unknown expect operator %s at %s:%d\n", expect.Function.Name, expect.Pos.Filename, expect.Pos.Line)
-						}
-					} else {
-						testFailed(mainEntery, invoke, &expect, offset, "Field %s not found on %s", code[0], invoke.RPC)
-					}
-
-				} else {
-					syntaxError((*mainEntery).Lines, expect.Pos, 0, "Unknown expect code \"%v\"", code[0])
+		if len(code) == 1 {
+			if expect.Function.Name == "isEmpty" {
+				if len(*invoke.Response) != 0 {
+					testFailed(mainEntery, invoke, expect, 0, "Response expected to be empty but it is not")
 				}
+			} else if fnErr := checkResponseValue(mainEntery.Lines, &run.namedInvokes, expect, *invoke.Response); fnErr != nil {
+				testFailed(mainEntery, invoke, expect, 0, "%s\n\nActual response:\n%s", fnErr.Error(), invoke.ResponseJson)
+			}
+			return expectResult
+		}
+		code := code[1:]
+		//fmt.Printf(" %s expect %v\n", invokeName, code)
+		offset := len("expect " + invokeName + "." + "response.")
+		if _, exists := (*invoke.Response)[code[0].Obj]; exists || code[0].Recursive {
+			val, err := resolvePath(invoke.referenceRoot(), expect.Path.Parts, pathEval(mainEntery.Lines, &run.namedInvokes, expect))
+			if err != nil {
+				testFailed(mainEntery, invoke, expect, offset, "%s: %v\n\nActual response:\n%s", expect.Path, err, invoke.ResponseJson)
+			} else if fnErr := checkPathValue(mainEntery.Lines, &run.namedInvokes, expect, val); fnErr != nil {
+				testFailed(mainEntery, invoke, expect, offset, "%s\n\nActual response:\n%s", fnErr.Error(), invoke.ResponseJson)
 			}
 		} else {
-			offset := len("invoke " + invokeName + " ")
-			knownEndPoints := make([]string, 0)
-			for k := range namedEndpointes {
-				knownEndPoints = append(knownEndPoints, k)
+			testFailed(mainEntery, invoke, expect, offset, "Field %s not found on %s", code[0], invoke.RPC)
+		}
+
+	} else if code[0].Obj == "headers" || code[0].Obj == "trailers" {
+		val, err := metadataValue(invoke.referenceRoot(), code)
+		if err != nil {
+			syntaxError(mainEntery.Lines, expect.Pos, 0, "%s", err.Error())
+		}
+		if fnErr := checkResponseValue(mainEntery.Lines, &run.namedInvokes, expect, val); fnErr != nil {
+			testFailed(mainEntery, invoke, expect, 0, "%s\n\nActual %s:\n%s", fnErr.Error(), code[0].Obj, metadataString(invoke.referenceRoot()[code[0].Obj]))
+		}
+	} else if code[0].Obj == "details" {
+		val, err := resolvePath(invoke.referenceRoot(), code, pathEval(mainEntery.Lines, &run.namedInvokes, expect))
+		if err != nil {
+			testFailed(mainEntery, invoke, expect, 0, "%s: %v\n\nActual details:\n%s", expect.Path, err, invoke.DetailsJson)
+		} else if fnErr := checkPathValue(mainEntery.Lines, &run.namedInvokes, expect, val); fnErr != nil {
+			testFailed(mainEntery, invoke, expect, 0, "%s\n\nActual details:\n%s", fnErr.Error(), invoke.DetailsJson)
+		}
+	} else if code[0].Obj == "latency" {
+		run.checkLatency(invoke, handler, expect)
+	} else if code[0].Obj == "responses" {
+		offset := expect.Pos.Column - 1
+		if fnErr := checkResponses(mainEntery.Lines, &run.namedInvokes, invoke, expect); fnErr != nil {
+			testFailed(mainEntery, invoke, expect, offset, "%s\n\nActual responses:\n%s", fnErr.Error(), invoke.ResponsesJson)
+		}
+	} else {
+		syntaxError((*mainEntery).Lines, expect.Pos, 0, "Unknown expect code \"%v\"", code[0])
+	}
+	return expectResult
+}
+
+// metadataToMap turns response headers or trailers into a map of their
+// lowercase keys, values of a repeated key are joined by ", " and binary
+// values are base64 encoded.
+func metadataToMap(md metadata.MD) map[string]any {
+	m := make(map[string]any, len(md))
+	for key, values := range md {
+		if strings.HasSuffix(key, "-bin") {
+			encoded := make([]string, len(values))
+			for i, value := range values {
+				encoded[i] = base64.StdEncoding.EncodeToString([]byte(value))
+			}
+			values = encoded
+		}
+		m[strings.ToLower(key)] = strings.Join(values, ", ")
+	}
+	return m
+}
+
+// metadataValue resolves headers["key"] or trailers["key"], a key which was not
+// received is null.
+func metadataValue(root map[string]any, parts []Part) (any, error) {
+	if len(parts) == 1 && len(parts[0].Acc) == 1 && parts[0].Acc[0].StrIndex != nil {
+		key, err := strconv.Unquote(*parts[0].Acc[0].StrIndex)
+		if err != nil {
+			key = *parts[0].Acc[0].StrIndex
+		}
+		md, _ := root[parts[0].Obj].(map[string]any)
+		return md[strings.ToLower(key)], nil
+	}
+	if len(parts) == 1 && len(parts[0].Acc) == 0 {
+		return root[parts[0].Obj], nil
+	}
+	return nil, fmt.Errorf("%s must be followed by [\"key\"]", parts[0].Obj)
+}
+
+func metadataString(md any) string {
+	jb, _ := json.MarshalIndent(md, "", "  ")
+	return string(jb)
+}
+
+// checkExchange makes sure every step of a bidirectional exchange got its own
+// reply, which arrived after the step's message was sent and before the next.
+func checkExchange(testEntery *Entry, invoke *Invoke, handler *grpcrunner.TRPCHandler) {
+	defaultFailBehave := "Panic"
+	for i, step := range invoke.Exchange {
+		stepExpect := &Expect{Pos: step.Pos, OnFail: &defaultFailBehave, Invoke: invoke}
+		if i >= len(handler.SentBeforeResponse) {
+			testFailed(testEntery, invoke, stepExpect, 0, "No reply received for message %d of the exchange, got %d reply(s)", i+1, len(handler.SentBeforeResponse))
+			return
+		}
+		if sent := handler.SentBeforeResponse[i]; sent <= i {
+			testFailed(testEntery, invoke, stepExpect, 0, "Reply %d received before message %d of the exchange was sent", i+1, i+1)
+		} else if sent > i+1 {
+			testFailed(testEntery, invoke, stepExpect, 0, "Reply %d received after message %d of the exchange was sent, it is not the reply to message %d", i+1, sent, i+1)
+		}
+	}
+}
+
+// functionArgs evaluates the arguments of the function of the expect.
+func functionArgs(lines *[]string, namedInvokes *NamedInvokes, expect *Expect) []any {
+	args := make([]any, len(expect.Function.Args))
+	for i, arg := range expect.Function.Args {
+		args[i], _ = arg.value(lines, namedInvokes, expect.Invoke.Variables, true)
+	}
+	return args
+}
+
+// pathEval evaluates the values of the filters of the path of the expect.
+func pathEval(lines *[]string, namedInvokes *NamedInvokes, expect *Expect) func(*Value) any {
+	return func(value *Value) any {
+		val, _ := value.value(lines, namedInvokes, expect.Invoke.Variables, true)
+		return val
+	}
+}
+
+// checkResponseValue applies the expect function to a value picked from a response message.
+func checkResponseValue(lines *[]string, namedInvokes *NamedInvokes, expect *Expect, val any) error {
+	fn, err := functions.DataFunction(expect.Function.Name, functionArgs(lines, namedInvokes, expect))
+	if err != nil {
+		syntaxError(lines, expect.Function.Pos, 0, "%s", err.Error())
+	}
+	if fnErr := fn(val); fnErr != nil {
+		return fmt.Errorf("%s %v", expect.Path.String(), fnErr)
+	}
+	return nil
+}
+
+// checkResponses examines the whole response stream of a (server) streaming
+// invoke, the list of its messages by "responses", a single message by
+// "responses[N].field" or every/some message by "every|some responses.field".
+func checkResponses(lines *[]string, namedInvokes *NamedInvokes, invoke *Invoke, expect *Expect) error {
+	parts := expect.Path.Parts
+	if expect.Quantifier == nil {
+		val, err := resolvePath(invoke.referenceRoot(), parts, pathEval(lines, namedInvokes, expect))
+		if err != nil {
+			return fmt.Errorf("%s: %v", expect.Path, err)
+		}
+		return checkResponseValue(lines, namedInvokes, expect, val)
+	}
+
+	if expect.Path.Multi() {
+		val, err := resolvePath(invoke.referenceRoot(), parts, pathEval(lines, namedInvokes, expect))
+		if err != nil {
+			return fmt.Errorf("%s: %v", expect.Path, err)
+		}
+		return checkPathValue(lines, namedInvokes, expect, val)
+	}
+	if len(parts[0].Acc) > 0 {
+		syntaxError(lines, expect.Pos, 0, "\"%s\" applies to every message, index is not allowed", *expect.Quantifier)
+	}
+	eval := pathEval(lines, namedInvokes, expect)
+	return checkQuantified(lines, namedInvokes, expect, "message on the stream", len(invoke.Responses), func(i int) (string, any, error) {
+		val, err := resolvePath(invoke.Responses[i], parts[1:], eval)
+		return fmt.Sprintf("responses[%d]", i), val, err
+	})
+}
+
+// checkPathValue applies the expect function to the value of its path, with a
+// quantifier to every or some of the values matched by the path.
+func checkPathValue(lines *[]string, namedInvokes *NamedInvokes, expect *Expect, val any) error {
+	if expect.Quantifier == nil {
+		return checkResponseValue(lines, namedInvokes, expect, val)
+	}
+	matches, _ := val.([]any)
+	return checkQuantified(lines, namedInvokes, expect, "value matched by the path", len(matches), func(i int) (string, any, error) {
+		return fmt.Sprintf("match %d", i), matches[i], nil
+	})
+}
+
+// checkQuantified applies the expect function to every or some of count items,
+// item returns the name, value and resolving error of the item i.
+func checkQuantified(lines *[]string, namedInvokes *NamedInvokes, expect *Expect, what string, count int, item func(i int) (string, any, error)) error {
+	errs := make([]string, 0)
+	for i := 0; i < count; i++ {
+		name, val, err := item(i)
+		if err == nil {
+			err = checkResponseValue(lines, namedInvokes, expect, val)
+		}
+		if err == nil {
+			if *expect.Quantifier == "some" {
+				return nil
 			}
-			invalidParameter((*mainEntery).Lines, invoke.Pos, offset, "Endpoint \"%s\" not found for Invoke \"%s\"\nKnown endpoints:\n%s\n", invoke.EndPoint, invokeName, strings.Join(knownEndPoints, "\n"))
+			continue
+		}
+		if *expect.Quantifier == "every" {
+			return fmt.Errorf("%s: %v", name, err)
 		}
+		errs = append(errs, fmt.Sprintf("%s: %v", name, err))
 	}
-	if mainEntery.Warnings+mainEntery.Warnings > 0 {
-		fmt.Printf("Test done with %d warning(s) and %d ignoration(s) \n", mainEntery.Warnings, mainEntery.Ignores)
+	if *expect.Quantifier == "some" {
+		return fmt.Errorf("No %s satisfies %s %s\n%s", what, expect.Path, expect.Function.Name, strings.Join(errs, "\n"))
 	}
-	println("✅ All tests passed as expected 😎")
+	return nil
 }
 
 func fatal(lines *[]string, pos lexer.Position, offset int, msg string, a ...interface{}) {
+	fatalLine(lines, pos, offset, offset > 0, msg, a...)
+}
+
+// fatalLine prints the message with the line of pos, and a caret under offset
+// when caret is set, even at the first column.
+func fatalLine(lines *[]string, pos lexer.Position, offset int, caret bool, msg string, a ...interface{}) {
+	if fileLines, ok := sourceLines[pos.Filename]; ok {
+		lines = fileLines
+	}
 	line := (*lines)[pos.Line-1]
 	newFormat := msg + "\nRelated line on file: %s:%d\n%s\n"
-	if offset > 0 {
+	if caret {
 		newFormat += strings.Repeat(" ", offset) + "^\n"
 	}
 
@@ -327,26 +798,35 @@ func syntaxError(lines *[]string, pos lexer.Position, offset int, msg string, a
 	fmt.Println("Something went wrong!")
 
 	fatal(lines, pos, offset, msg, a...)
-	os.Exit(2)
+	panic(trpcError{ExitCode: 2, Msg: fmt.Sprintf(msg, a...)})
 }
 
+// outputLock keeps the report of a failure in one piece and its counting
+// safe when invokes run in parallel.
+var outputLock sync.Mutex
+
 func testFailed(testEntery *Entry, invoke *Invoke, expect *Expect, offset int, msg string, a ...interface{}) string {
+	outputLock.Lock()
+	defer outputLock.Unlock()
 	invokeCondition := NewInvokeCondition(*expect.OnFail, expect, fmt.Sprintf(msg, a...))
+	severityStr := invokeCondition.String()
+	if invoke.quiet {
+		return severityStr
+	}
 	colorFn := color.Red
-	severityStr := "Panic"
 	failSign := "⛔"
 	switch invokeCondition.Condition {
 	case InvokeDoneWithIgnores:
 		{
 			colorFn = color.Yellow
 			failSign = "‼️"
-			testEntery.Warnings += 1
+			testEntery.Ignores += 1
 		}
 	case InvokeDoneWithWarnings:
 		{
 			colorFn = color.HiYellow
 			failSign = "❕"
-			testEntery.Ignores += 1
+			testEntery.Warnings += 1
 		}
 		//case InvokeFailed:
 		//Done already
@@ -359,22 +839,14 @@ func testFailed(testEntery *Entry, invoke *Invoke, expect *Expect, offset int, m
 	}
 	fatal(testEntery.Lines, expect.Pos, offset, severityStr+": "+msg, a...)
 
-	expect.Invoke.Conditions = append(expect.Invoke.Conditions, invokeCondition)
-
-	//Warn and Ignore will not break the test follow
-	if severityStr == "Warn" || severityStr == "Ignore" {
-		return severityStr
-	}
-
-	os.Exit(3)
-	//We get out I knew, but needed to compile
-	return ""
+	//Neither of the severities break the test follow, the result keeps the condition
+	return severityStr
 }
 
 func invalidParameter(lines *[]string, pos lexer.Position, offset int, msg string, a ...interface{}) {
 	fmt.Println("Invalid code error :")
 	fatal(lines, pos, offset, msg, a...)
-	os.Exit(2)
+	panic(trpcError{ExitCode: 2, Msg: fmt.Sprintf(msg, a...)})
 }
 
 func typeOf(val interface{}) string {
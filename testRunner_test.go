@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"trpc/grpcrunner"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// unaryServer answers a unary call after response_size milliseconds with the
+// username "user-<response_size>", a size of 404 is not found. It keeps the
+// order the calls were answered in.
+type unaryServer struct {
+	testpb.UnimplementedTestServiceServer
+	mu       sync.Mutex
+	answered []int32
+}
+
+func (s *unaryServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if req.ResponseSize == 404 {
+		return nil, status.Errorf(codes.NotFound, "size %d not found", req.ResponseSize)
+	}
+	time.Sleep(time.Duration(req.ResponseSize) * time.Millisecond)
+	s.mu.Lock()
+	s.answered = append(s.answered, req.ResponseSize)
+	s.mu.Unlock()
+	return &testpb.SimpleResponse{Username: fmt.Sprintf("user-%d", req.ResponseSize)}, nil
+}
+
+// serveTest serves the test service with reflection on a local port, it
+// returns the port.
+func serveTest(t *testing.T, service testpb.TestServiceServer) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, service)
+	reflection.Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// runParallel runs the invokes of the source on the port with parallel and
+// fails the test when they do not finish in time.
+func runParallel(t *testing.T, port int, source string, parallel int) map[string]*InvokeResult {
+	t.Helper()
+	trpc := parseTrpc(t, "parallel.trpc", fmt.Sprintf("%s\nendpoint local \"127.0.0.1\" port %d\n%s", testHeader, port, source))
+	run := newTestRun(trpc.Entries[0])
+	run.load(trpc, "")
+	run.session = grpcrunner.NewSession()
+	defer run.session.Close()
+
+	var results []*InvokeResult
+	captureOutput(t, func() {
+		done := make(chan []*InvokeResult)
+		go func() { done <- run.runInvokes(run.invokeOrder, parallel) }()
+		select {
+		case results = <-done:
+		case <-time.After(10 * time.Second):
+		}
+	})
+	if results == nil {
+		t.Fatal("invokes did not finish")
+	}
+	named := make(map[string]*InvokeResult)
+	for _, result := range results {
+		named[result.Invoke.Name] = result
+	}
+	return named
+}
+
+func TestRunInvokesWaitsForReferences(t *testing.T) {
+	server := &unaryServer{}
+	port := serveTest(t, server)
+	results := runParallel(t, port, `
+invoke slow local grpc.testing.TestService UnaryCall data { response_size: 300 }
+invoke fast local grpc.testing.TestService UnaryCall data { response_size: 0 }
+invoke dependent local grpc.testing.TestService UnaryCall data { response_size: 1 } expects {
+	response.username isNotEqual(slow.response.username)
+}
+`, 3)
+	for name, result := range results {
+		if status := result.Status(); status != ResultPassed {
+			t.Errorf("%s: status %s, want passed", name, resultStatusNames[status])
+		}
+	}
+	// fast does not wait for slow, dependent does
+	want := []int32{0, 300, 1}
+	if fmt.Sprint(server.answered) != fmt.Sprint(want) {
+		t.Errorf("calls answered in order %v, want %v", server.answered, want)
+	}
+}
+
+func TestRunInvokesFailedReference(t *testing.T) {
+	port := serveTest(t, &unaryServer{})
+	results := runParallel(t, port, `
+invoke broken local grpc.testing.TestService UnaryCall data { response_size: 404 } expects {
+	code isOk()
+}
+invoke dependent local grpc.testing.TestService UnaryCall data { response_size: 1 } expects {
+	response.username isEqual(broken.response.username)
+}
+invoke second local grpc.testing.TestService UnaryCall data { response_size: 1 } expects {
+	response.username isEqual(dependent.response.username)
+}
+invoke other local grpc.testing.TestService UnaryCall data { response_size: 1 }
+`, 2)
+	for _, test := range []struct {
+		name   string
+		status ResultStatus
+		reason string
+	}{
+		{"broken", ResultFailed, ""},
+		{"dependent", ResultSkipped, "referenced invoke broken did not pass"},
+		{"second", ResultSkipped, "referenced invoke dependent did not pass"},
+		{"other", ResultPassed, ""},
+	} {
+		result := results[test.name]
+		if status := result.Status(); status != test.status {
+			t.Errorf("%s: status %s, want %s", test.name, resultStatusNames[status], resultStatusNames[test.status])
+		}
+		if !strings.Contains(result.SkipReason, test.reason) {
+			t.Errorf("%s: skipped as %q, want %q", test.name, result.SkipReason, test.reason)
+		}
+	}
+}
+
+// runFile runs the source with an endpoint local on the port as run does.
+func runFile(t *testing.T, port int, source string) *TestResult {
+	t.Helper()
+	trpc := parseTrpc(t, "phases.trpc", fmt.Sprintf("%s\nendpoint local \"127.0.0.1\" port %d\n%s", testHeader, port, source))
+	var result *TestResult
+	captureOutput(t, func() { result = TasteAndRun(trpc) })
+	return result
+}
+
+// statuses are the names and statuses of the results, in order.
+func statuses(results []*InvokeResult) string {
+	named := make([]string, len(results))
+	for i, result := range results {
+		named[i] = result.Invoke.Name + ":" + resultStatusNames[result.Status()]
+	}
+	return strings.Join(named, " ")
+}
+
+func TestSetupAndTeardown(t *testing.T) {
+	port := serveTest(t, &unaryServer{})
+	for _, test := range []struct {
+		name     string
+		source   string
+		invokes  string
+		teardown string
+		code     int
+	}{
+		{
+			name: "teardown after a failed invoke, referencing setup",
+			source: `
+setup {
+	invoke login local grpc.testing.TestService UnaryCall data { response_size: 1 }
+}
+invoke broken local grpc.testing.TestService UnaryCall data { response_size: 404 } expects {
+	code isOk()
+}
+teardown {
+	invoke logout local grpc.testing.TestService UnaryCall data { response_size: 1 } expects {
+		response.username isEqual(login.response.username)
+	}
+}
+`,
+			invokes:  "login:passed broken:failed",
+			teardown: "logout:passed",
+			code:     3,
+		},
+		{
+			name: "teardown after a failed setup",
+			source: `
+setup {
+	invoke login local grpc.testing.TestService UnaryCall data { response_size: 404 } expects {
+		code isOk()
+	}
+}
+invoke first local grpc.testing.TestService UnaryCall data { response_size: 1 }
+invoke second local grpc.testing.TestService UnaryCall data { response_size: 1 }
+teardown {
+	invoke cleanup local grpc.testing.TestService UnaryCall data { response_size: 1 }
+}
+`,
+			invokes:  "login:failed first:skipped second:skipped",
+			teardown: "cleanup:passed",
+			code:     3,
+		},
+		{
+			name: "failed teardown",
+			source: `
+invoke create local grpc.testing.TestService UnaryCall data { response_size: 1 }
+teardown {
+	invoke remove local grpc.testing.TestService UnaryCall data { response_size: 404 } expects {
+		code isOk()
+	}
+}
+`,
+			invokes:  "create:passed",
+			teardown: "remove:failed",
+			code:     3,
+		},
+	} {
+		result := runFile(t, port, test.source)
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.name, result.Err)
+			continue
+		}
+		if got := statuses(result.Invokes); got != test.invokes {
+			t.Errorf("%s: invokes %s, want %s", test.name, got, test.invokes)
+		}
+		if got := statuses(result.Teardown); got != test.teardown {
+			t.Errorf("%s: teardown %s, want %s", test.name, got, test.teardown)
+		}
+		if code := result.ExitCode(); code != test.code {
+			t.Errorf("%s: exit code %d, want %d", test.name, code, test.code)
+		}
+		for _, invoke := range result.Invokes {
+			if invoke.Status() == ResultSkipped && invoke.SkipReason != "setup invoke login did not pass" {
+				t.Errorf("%s: %s skipped as %q", test.name, invoke.Invoke.Name, invoke.SkipReason)
+			}
+		}
+	}
+}
+
+func TestMetadataToMap(t *testing.T) {
+	md := metadataToMap(metadata.MD{
+		"X-Request-Id": []string{"abc"},
+		"set-cookie":   []string{"a=1", "b=2"},
+		"trace-bin":    []string{"\x01\x02", "\xff"},
+	})
+	want := map[string]any{
+		"x-request-id": "abc",
+		"set-cookie":   "a=1, b=2",
+		"trace-bin":    "AQI=, /w==",
+	}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("metadata = %#v, want %#v", md, want)
+	}
+}
+
+func TestMetadataValue(t *testing.T) {
+	root := map[string]any{
+		"headers":  map[string]any{"x-request-id": "abc"},
+		"trailers": map[string]any{},
+	}
+	for _, test := range []struct {
+		path string
+		want any
+		err  string
+	}{
+		{path: `headers["X-Request-Id"]`, want: "abc"},
+		{path: `headers["missing"]`, want: nil},
+		{path: `trailers["missing"]`, want: nil},
+		{path: `trailers`, want: map[string]any{}},
+		{path: `headers[0]`, err: `headers must be followed by ["key"]`},
+		{path: `headers["a"]["b"]`, err: `headers must be followed by ["key"]`},
+		{path: `headers.x`, err: `headers must be followed by ["key"]`},
+	} {
+		got, err := metadataValue(root, parsePath(t, test.path).Parts)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error %v, want %q", test.path, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, %v, want %#v", test.path, got, err, test.want)
+		}
+	}
+}
+
+// streamingServer streams a message with the body "message-N" for each of the
+// response parameters.
+type streamingServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (s *streamingServer) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for i := range req.ResponseParameters {
+		body := []byte(fmt.Sprintf("message-%d", i+1))
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: &testpb.Payload{Body: body}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCheckResponses(t *testing.T) {
+	port := serveTest(t, &streamingServer{})
+	// bodies are bytes, which responses hold in base64
+	body := func(n int) string {
+		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("message-%d", n)))
+	}
+	for _, test := range []struct {
+		expect string
+		status string
+	}{
+		{`responses hasCount(3)`, "passed"},
+		{`responses hasCount(2)`, "failed"},
+		{fmt.Sprintf(`responses[1].payload.body isEqual(%q)`, body(2)), "passed"},
+		{fmt.Sprintf(`responses[-1].payload.body isEqual(%q)`, body(3)), "passed"},
+		{fmt.Sprintf(`responses[0].payload.body isEqual(%q)`, body(2)), "failed"},
+		{`responses[3].payload.body hasValue()`, "failed"},
+		{`every responses.payload.body hasValue()`, "passed"},
+		{fmt.Sprintf(`every responses.payload.body isEqual(%q)`, body(1)), "failed"},
+		{fmt.Sprintf(`some responses.payload.body isEqual(%q)`, body(2)), "passed"},
+		{`some responses.payload.body isEqual("nope")`, "failed"},
+	} {
+		result := runFile(t, port, fmt.Sprintf(`
+invoke stream local grpc.testing.TestService StreamingOutputCall data {
+	response_parameters: [{ size: 1 }, { size: 1 }, { size: 1 }]
+} expects {
+	%s
+}
+`, test.expect))
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.expect, result.Err)
+			continue
+		}
+		if got := resultStatusNames[result.Invokes[0].Status()]; got != test.status {
+			t.Errorf("%s: status %s, want %s", test.expect, got, test.status)
+		}
+	}
+}
+
+func TestImpliesTls(t *testing.T) {
+	tests := []struct {
+		option    string
+		json      string
+		gen       genCmd
+		plaintext bool
+	}{
+		{"", ``, genCmd{}, true},
+		{`cacert "ca.pem"`, `"cacert": "ca.pem"`, genCmd{Cacert: "ca.pem"}, false},
+		{`cert "c.pem" key "k.pem"`, `"cert": "c.pem", "key": "k.pem"`, genCmd{Cert: "c.pem", Key: "k.pem"}, false},
+		{`insecureSkipVerify`, `"insecureSkipVerify": true`, genCmd{InsecureSkipVerify: true}, false},
+		{`serverName "api"`, `"serverName": "api"`, genCmd{ServerName: "api"}, false},
+		{`authority "api"`, `"authority": "api"`, genCmd{Authority: "api"}, true},
+	}
+	// endpoint fileN has the option in the file, profileN by the profile
+	source := testHeader + "\n"
+	overrides := make([]string, len(tests))
+	for i, test := range tests {
+		source += fmt.Sprintf("endpoint file%d \"h\" port 1 %s\nendpoint profile%d \"h\" port 1\n", i, test.option, i)
+		overrides[i] = fmt.Sprintf(`"profile%d": { %s }`, i, test.json)
+	}
+	dir := writeFiles(t, map[string]string{
+		"a.trpc":        source,
+		profileFileName: fmt.Sprintf(`{ "tls": { "endpoints": { %s } } }`, strings.Join(overrides, ", ")),
+	})
+	run := loadFile(t, filepath.Join(dir, "a.trpc"), "tls")
+	for i, test := range tests {
+		for _, name := range []string{fmt.Sprintf("file%d", i), fmt.Sprintf("profile%d", i)} {
+			if plaintext := run.endpointParams(run.namedEndpointes[name]).Plaintext; plaintext != test.plaintext {
+				t.Errorf("endpoint %s with %q: plaintext %v, want %v", name, test.option, plaintext, test.plaintext)
+			}
+		}
+		if plaintext := !test.gen.tls(); plaintext != test.plaintext {
+			t.Errorf("gen with %q: plaintext %v, want %v", test.option, plaintext, test.plaintext)
+		}
+	}
+}
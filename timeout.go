@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"trpc/grpcrunner"
+)
+
+// checkTimeouts evaluates the timeout and the deadline of the invoke, which
+// replace the timeout and the maxtime of the test for its calls:
+//
+//	invoke order local shop.Orders Get timeout "2s" deadline "500ms" data {
+//	  id: 1
+//	}
+func (run *testRun) checkTimeouts(invoke *Invoke) {
+	if invoke.Timeout != nil {
+		invoke.TimeoutValue = run.durationValue(invoke, invoke.Timeout, "timeout")
+	}
+	if invoke.Deadline != nil {
+		invoke.DeadlineValue = run.durationValue(invoke, invoke.Deadline, "deadline")
+	}
+}
+
+// durationValue evaluates a duration of the invoke, a string is a duration
+// such as "500ms" and a number is in seconds.
+func (run *testRun) durationValue(invoke *Invoke, value *Value, what string) time.Duration {
+	lines := run.mainEntery.Lines
+	val, haveReference := value.value(lines, &run.namedInvokes, invoke.Variables, false)
+	if haveReference {
+		syntaxError(lines, value.Pos, 0, "The %s of invoke %s can not reference invokes", what, invoke.Name)
+	}
+	var duration time.Duration
+	switch v := val.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			syntaxError(lines, value.Pos, 0, "Invalid %s \"%s\": %v", what, v, err)
+		}
+		duration = parsed
+	case int64:
+		duration = time.Duration(v) * time.Second
+	case float64:
+		duration = time.Duration(v * float64(time.Second))
+	default:
+		syntaxError(lines, value.Pos, 0, "The %s must be a duration such as \"500ms\" but got %v", what, val)
+	}
+	if duration < 0 {
+		syntaxError(lines, value.Pos, 0, "The %s can not be negative but got %v", what, duration)
+	}
+	return duration
+}
+
+// timeouts are the connect timeout and the max time of a call of the invoke
+// in seconds, as grpcrunner takes them.
+func (run *testRun) timeouts(invoke *Invoke) (connectTimeout float64, maxTime float64) {
+	connectTimeout, maxTime = run.connectionTimeout, run.maxTime
+	if invoke.TimeoutValue > 0 {
+		connectTimeout = invoke.TimeoutValue.Seconds()
+	}
+	if invoke.DeadlineValue > 0 {
+		maxTime = invoke.DeadlineValue.Seconds()
+	}
+	return connectTimeout, maxTime
+}
+
+// checkLatency examines the wall-clock time of the RPC, latency
+// isLessThan("200ms"), with the functions comparing durations.
+func (run *testRun) checkLatency(invoke *Invoke, handler *grpcrunner.TRPCHandler, expect *Expect) {
+	mainEntery := run.mainEntery
+	if len(expect.Path.Parts) != 1 || len(expect.Path.Parts[0].Acc) != 0 {
+		syntaxError(mainEntery.Lines, expect.Pos, 0, "latency has no fields but got %s", expect.Path)
+	}
+	latency := handler.Latency.Round(time.Microsecond)
+	if fnErr := checkResponseValue(mainEntery.Lines, &run.namedInvokes, expect, latency); fnErr != nil {
+		testFailed(mainEntery, invoke, expect, 0, "%s", fnErr.Error())
+	}
+}
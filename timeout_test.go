@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTimeouts loads the invokes of the source in a file whose header sets
+// maxtime 9 and timeout 7.
+func loadTimeouts(t *testing.T, source string) *testRun {
+	t.Helper()
+	trpc := parseTrpc(t, "timeouts.trpc", fmt.Sprintf("%s maxtime 9.0 timeout 7.0\nendpoint local \"127.0.0.1\" port 50051\n%s", testHeader, source))
+	run := newTestRun(trpc.Entries[0])
+	run.load(trpc, "")
+	return run
+}
+
+func TestDurationValue(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: `"2s"`, want: 2 * time.Second},
+		{value: `"1m30s"`, want: 90 * time.Second},
+		{value: `3`, want: 3 * time.Second},
+		{value: `1.5`, want: 1500 * time.Millisecond},
+		{value: `"-1s"`, err: "The timeout can not be negative but got -1s"},
+		{value: `"soon"`, err: `Invalid timeout "soon"`},
+		{value: `true`, err: `The timeout must be a duration such as "500ms" but got true`},
+	} {
+		source := fmt.Sprintf("invoke a local grpc.testing.TestService UnaryCall timeout %s data {}", test.value)
+		var run *testRun
+		err := recoverError(t, func() { run = loadTimeouts(t, source) })
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("timeout %s: error %v, want %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("timeout %s: %v", test.value, err)
+			continue
+		}
+		if got := run.namedInvokes["a"].TimeoutValue; got != test.want {
+			t.Errorf("timeout %s = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	run := loadTimeouts(t, `
+invoke file local grpc.testing.TestService UnaryCall data {}
+invoke timeout local grpc.testing.TestService UnaryCall timeout "2s" data {}
+invoke deadline local grpc.testing.TestService UnaryCall deadline "500ms" data {}
+invoke both local grpc.testing.TestService UnaryCall timeout 1 deadline 3 data {}
+`)
+	for _, test := range []struct {
+		invoke         string
+		connectTimeout float64
+		maxTime        float64
+	}{
+		{"file", 7, 9},
+		{"timeout", 2, 9},
+		{"deadline", 7, 0.5},
+		{"both", 1, 3},
+	} {
+		connectTimeout, maxTime := run.timeouts(run.namedInvokes[test.invoke])
+		if connectTimeout != test.connectTimeout || maxTime != test.maxTime {
+			t.Errorf("%s: timeouts %v, %v, want %v, %v", test.invoke, connectTimeout, maxTime, test.connectTimeout, test.maxTime)
+		}
+	}
+}
+
+func TestCheckLatency(t *testing.T) {
+	port := serveTest(t, &unaryServer{})
+	// the server answers after response_size milliseconds
+	result := runFile(t, port, `
+invoke slow local grpc.testing.TestService UnaryCall data { response_size: 100 } expects {
+	latency isGreaterThan("100ms")
+	latency isLessThan("5s")
+}
+invoke fast local grpc.testing.TestService UnaryCall data { response_size: 0 } expects {
+	latency isGreaterThan("5s")
+}
+invoke between local grpc.testing.TestService UnaryCall data { response_size: 100 } expects {
+	latency isBetween("1ms", "50ms")
+}
+`)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if got, want := statuses(result.Invokes), "slow:passed fast:failed between:failed"; got != want {
+		t.Errorf("invokes %s, want %s", got, want)
+	}
+	for _, invoke := range result.Invokes {
+		if invoke.Invoke.Name == "slow" && invoke.Latency < 100*time.Millisecond {
+			t.Errorf("slow: latency %v, want at least 100ms", invoke.Latency)
+		}
+	}
+}
@@ -1,57 +1,244 @@
 package trpc_marshal
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"trpc/grpcrunner"
 
+	"github.com/fullstorydev/grpcurl"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 dynamic messages are of this API
 	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
+
+	// Register the well known error details of google.rpc
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
 )
 
-func marshalField(value any, field *desc.FieldDescriptor) any {
-	if field.GetType() == dpb.FieldDescriptorProto_TYPE_ENUM {
-		field.GetEnumType().FindValueByNumber(value.(int32)).GetName()
-	}
-	return value
+// wellKnownTypes are converted to their canonical JSON form, a string for
+// Timestamp, Duration and FieldMask, the wrapped value for wrappers and plain
+// JSON for Struct, Value and ListValue.
+var wellKnownTypes = map[string]bool{
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.Struct":      true,
+	"google.protobuf.Value":       true,
+	"google.protobuf.ListValue":   true,
+	"google.protobuf.Empty":       true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
 }
 
-func marshalRepeated(msg []interface{}, field *desc.FieldDescriptor) []any {
-	repeated := make([]interface{}, 0)
-	if field.GetType() == dpb.FieldDescriptorProto_TYPE_MESSAGE {
-		fields := field.GetMessageType().GetFields()
-		for _, v := range msg {
-			repeated = append(repeated, marshalMsg(v.(*dynamic.Message), fields))
+const anyTypeName = "google.protobuf.Any"
+
+// converter converts messages to maps, Any messages are resolved with source
+// first and then with the types linked into trpc.
+type converter struct {
+	source grpcurl.DescriptorSource
+}
+
+// convertValue converts a value of the field by the type of the field:
+//
+//	double, float                       float64
+//	int32, sint32, sfixed32             int32
+//	int64, sint64, sfixed64             int64
+//	uint32, fixed32                     uint32
+//	uint64, fixed64                     uint64
+//	bool                                bool
+//	string                              string
+//	bytes                               []byte
+//	enum                                name of the value as string, the number as int32 when the value is unknown
+//	message, group                      map[string]any by field name
+//	well known types                    their canonical JSON form
+//	Any                                 the map of the packed message with its type URL as "@type"
+//	repeated                            []any of the converted items, empty when there are none
+//	map                                 map[string]any of the converted values by the key formatted as string
+//
+// A scalar or enum of proto3 without presence holds its zero value when it is
+// not set, the name of the enum value 0 for enums. A message field, the other
+// fields of a oneof and an optional field which are not set are nil.
+func (c converter) convertValue(value any, field *desc.FieldDescriptor) any {
+	switch field.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		number, _ := value.(int32)
+		if enumValue := field.GetEnumType().FindValueByNumber(number); enumValue != nil {
+			return enumValue.GetName()
+		}
+		return number
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		// keep the shortest decimal of the float32 rather than its float64 expansion
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value.(float32)), 'g', -1, 32), 64)
+		return f
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		// empty bytes are never null when written as JSON
+		if bytes, _ := value.([]byte); bytes == nil {
+			return []byte{}
+		}
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		message, ok := value.(proto.Message)
+		if !ok {
+			return nil
 		}
-	} else if field.GetType() == dpb.FieldDescriptorProto_TYPE_ENUM {
-		for _, v := range msg {
-			repeated = append(repeated, marshalField(v, field))
+		dynMsg, err := dynamic.AsDynamicMessage(message)
+		if err != nil {
+			return nil
 		}
+		return c.convertMessage(dynMsg)
+	}
+	return value
+}
+
+func (c converter) convertRepeated(values []interface{}, field *desc.FieldDescriptor) []any {
+	repeated := make([]any, len(values))
+	for i, v := range values {
+		repeated[i] = c.convertValue(v, field)
 	}
 	return repeated
 }
 
-func marshalMsg(msg *dynamic.Message, fields []*desc.FieldDescriptor) map[string]any {
-	marshalled := make(map[string]any, 0)
+func (c converter) convertMap(values map[interface{}]interface{}, field *desc.FieldDescriptor) map[string]any {
+	valueField := field.GetMapValueType()
+	converted := make(map[string]any, len(values))
+	for k, v := range values {
+		converted[fmt.Sprint(k)] = c.convertValue(v, valueField)
+	}
+	return converted
+}
+
+// convertMessage converts a message, well known types and Any included.
+func (c converter) convertMessage(msg *dynamic.Message) any {
+	name := msg.GetMessageDescriptor().GetFullyQualifiedName()
+	if wellKnownTypes[name] {
+		jb, err := msg.MarshalJSONPB(&jsonpb.Marshaler{OrigName: true})
+		if err == nil {
+			var canonical any
+			if err = json.Unmarshal(jb, &canonical); err == nil {
+				return canonical
+			}
+		}
+	}
+	if name == anyTypeName {
+		typeURL, _ := msg.GetFieldByName("type_url").(string)
+		value, _ := msg.GetFieldByName("value").([]byte)
+		return c.convertAny(typeURL, value)
+	}
+	return c.messageToMap(msg)
+}
+
+func (c converter) messageToMap(msg *dynamic.Message) map[string]any {
+	fields := msg.GetMessageDescriptor().GetFields()
+	converted := make(map[string]any, len(fields))
 	for _, field := range fields {
-		if field.IsRepeated() {
-			message := msg.GetField(field).([]interface{})
-			marshalled[field.GetName()] = marshalRepeated(message, field)
-		} else {
-			if msg.HasField(field) {
-				marshalled[field.GetName()] = marshalField(msg.GetField(field), field)
-			} else {
-				marshalled[field.GetName()] = nil
+		switch {
+		case field.IsMap():
+			values, _ := msg.GetField(field).(map[interface{}]interface{})
+			converted[field.GetName()] = c.convertMap(values, field)
+		case field.IsRepeated():
+			values, _ := msg.GetField(field).([]interface{})
+			converted[field.GetName()] = c.convertRepeated(values, field)
+		case msg.HasField(field), implicitPresence(field):
+			converted[field.GetName()] = c.convertValue(msg.GetField(field), field)
+		default:
+			converted[field.GetName()] = nil
+		}
+	}
+	return converted
+}
+
+// implicitPresence tells the field of proto3 can not tell being unset from
+// holding its zero value, a scalar or enum which is neither in a oneof nor
+// optional.
+func implicitPresence(field *desc.FieldDescriptor) bool {
+	switch field.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE, dpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return field.GetFile().IsProto3() && field.GetOneOf() == nil
+}
+
+// resolve finds the message descriptor of a type URL.
+func (c converter) resolve(typeURL string) (*desc.MessageDescriptor, error) {
+	typeName := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	if c.source != nil {
+		if symbol, err := c.source.FindSymbol(typeName); err == nil {
+			if md, ok := symbol.(*desc.MessageDescriptor); ok {
+				return md, nil
 			}
 		}
 	}
-	return marshalled
+	return desc.LoadMessageDescriptor(typeName)
+}
+
+// convertAny converts the packed message with its type URL as "@type", a
+// message of an unknown type only has "@type" and its base64 "value".
+func (c converter) convertAny(typeURL string, value []byte) map[string]any {
+	unknown := map[string]any{
+		"@type": typeURL,
+		"value": base64.StdEncoding.EncodeToString(value),
+	}
+	md, err := c.resolve(typeURL)
+	if err != nil || md == nil {
+		return unknown
+	}
+	msg := dynamic.NewMessage(md)
+	if err := msg.Unmarshal(value); err != nil {
+		return unknown
+	}
+	converted, ok := c.convertMessage(msg).(map[string]any)
+	if !ok {
+		// a packed well known type keeps its canonical form under "value"
+		converted = map[string]any{"value": c.convertMessage(msg)}
+	}
+	converted["@type"] = typeURL
+	return converted
+}
+
+// MessageToMap converts every field of the message by its proto name.
+func MessageToMap(msg *dynamic.Message) map[string]any {
+	return converter{}.messageToMap(msg)
 }
 
 func RPCMessageToMap(handler grpcrunner.TRPCHandler) map[string]any {
-	message := handler.ResponseData[0]
+	reflMsg, _ := dynamic.AsDynamicMessage(handler.ResponseData[0])
+	return converter{source: handler.Descriptor}.messageToMap(reflMsg)
+}
 
-	mdRes := handler.MethodDescriptor.GetOutputType()
-	reflMsg, _ := dynamic.AsDynamicMessage(message)
+// RPCMessagesToMaps marshals every message of the response stream in the order
+// they were received, for unary calls the result has at most one message.
+func RPCMessagesToMaps(handler grpcrunner.TRPCHandler) []map[string]any {
+	c := converter{source: handler.Descriptor}
+	messages := make([]map[string]any, len(handler.ResponseData))
+	for i, message := range handler.ResponseData {
+		reflMsg, _ := dynamic.AsDynamicMessage(message)
+		messages[i] = c.messageToMap(reflMsg)
+	}
+	return messages
+}
 
-	return marshalMsg(reflMsg, mdRes.GetFields())
+// StatusDetailsToMaps decodes the details of the response status as Any
+// messages, resolved with the descriptor source of the RPC and then with the
+// well known error details.
+func StatusDetailsToMaps(handler grpcrunner.TRPCHandler) []any {
+	details := make([]any, 0)
+	if handler.Status == nil {
+		return details
+	}
+	c := converter{source: handler.Descriptor}
+	for _, detail := range handler.Status.Proto().GetDetails() {
+		details = append(details, c.convertAny(detail.GetTypeUrl(), detail.GetValue()))
+	}
+	return details
 }
@@ -0,0 +1,326 @@
+package trpc_marshal
+
+import (
+	"reflect"
+	"testing"
+
+	"trpc/grpcrunner"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const testProto = `
+syntax = "proto3";
+package test;
+
+enum Color {
+	RED = 0;
+	GREEN = 1;
+}
+
+message Inner {
+	string name = 1;
+	Color color = 2;
+}
+
+message Outer {
+	double d = 1;
+	float f = 2;
+	int32 i32 = 3;
+	sint32 s32 = 4;
+	sfixed32 sf32 = 5;
+	int64 i64 = 6;
+	sint64 s64 = 7;
+	sfixed64 sf64 = 8;
+	uint32 u32 = 9;
+	fixed32 f32 = 10;
+	uint64 u64 = 11;
+	fixed64 f64 = 12;
+	bool b = 13;
+	string s = 14;
+	bytes by = 15;
+	Color color = 16;
+	Inner inner = 17;
+	repeated int32 ints = 18;
+	repeated string strs = 19;
+	repeated Color colors = 20;
+	repeated Inner inners = 21;
+	map<string, int64> counts = 22;
+	map<int32, Inner> byId = 23;
+	oneof choice {
+		string text = 24;
+		Inner other = 25;
+	}
+	optional int32 maybe = 26;
+}
+`
+
+func outerDescriptor(t *testing.T) *desc.MessageDescriptor {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"test.proto": testProto}),
+	}
+	files, err := parser.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatalf("parse test.proto: %v", err)
+	}
+	return files[0].FindMessage("test.Outer")
+}
+
+func TestMessageToMapScalars(t *testing.T) {
+	md := outerDescriptor(t)
+	msg := dynamic.NewMessage(md)
+	msg.SetFieldByName("d", 1.5)
+	msg.SetFieldByName("f", float32(0.1))
+	msg.SetFieldByName("i32", int32(-3))
+	msg.SetFieldByName("s32", int32(-4))
+	msg.SetFieldByName("sf32", int32(-5))
+	msg.SetFieldByName("i64", int64(-1)<<40)
+	msg.SetFieldByName("s64", int64(-7))
+	msg.SetFieldByName("sf64", int64(-8))
+	msg.SetFieldByName("u32", uint32(9))
+	msg.SetFieldByName("f32", uint32(10))
+	msg.SetFieldByName("u64", uint64(1)<<63)
+	msg.SetFieldByName("f64", uint64(12))
+	msg.SetFieldByName("b", true)
+	msg.SetFieldByName("s", "str")
+	msg.SetFieldByName("by", []byte{1, 2, 3})
+	msg.SetFieldByName("color", int32(1))
+
+	m := MessageToMap(msg)
+	expected := map[string]any{
+		"d":     1.5,
+		"f":     0.1,
+		"i32":   int32(-3),
+		"s32":   int32(-4),
+		"sf32":  int32(-5),
+		"i64":   int64(-1) << 40,
+		"s64":   int64(-7),
+		"sf64":  int64(-8),
+		"u32":   uint32(9),
+		"f32":   uint32(10),
+		"u64":   uint64(1) << 63,
+		"f64":   uint64(12),
+		"b":     true,
+		"s":     "str",
+		"by":    []byte{1, 2, 3},
+		"color": "GREEN",
+	}
+	for name, want := range expected {
+		if got := m[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = (%T) %v, want (%T) %v", name, got, got, want, want)
+		}
+	}
+}
+
+func TestMessageToMapUnset(t *testing.T) {
+	m := MessageToMap(dynamic.NewMessage(outerDescriptor(t)))
+	// proto3 scalars and enums without presence hold their zero value
+	zeros := map[string]any{
+		"d":     0.0,
+		"f":     0.0,
+		"i32":   int32(0),
+		"i64":   int64(0),
+		"u32":   uint32(0),
+		"u64":   uint64(0),
+		"b":     false,
+		"s":     "",
+		"by":    []byte{},
+		"color": "RED",
+	}
+	for name, want := range zeros {
+		if got := m[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = (%T) %#v, want (%T) %#v", name, got, got, want, want)
+		}
+	}
+	for _, name := range []string{"inner", "text", "other", "maybe"} {
+		if m[name] != nil {
+			t.Errorf("%s = %v, want nil", name, m[name])
+		}
+	}
+	for _, name := range []string{"ints", "strs", "colors", "inners"} {
+		if got, ok := m[name].([]any); !ok || len(got) != 0 {
+			t.Errorf("%s = (%T) %v, want empty []any", name, m[name], m[name])
+		}
+	}
+	for _, name := range []string{"counts", "byId"} {
+		if got, ok := m[name].(map[string]any); !ok || len(got) != 0 {
+			t.Errorf("%s = (%T) %v, want empty map", name, m[name], m[name])
+		}
+	}
+}
+
+func TestMessageToMapComposites(t *testing.T) {
+	md := outerDescriptor(t)
+	innerMd := md.FindFieldByName("inner").GetMessageType()
+	inner := func(name string, color int32) *dynamic.Message {
+		msg := dynamic.NewMessage(innerMd)
+		msg.SetFieldByName("name", name)
+		msg.SetFieldByName("color", color)
+		return msg
+	}
+
+	msg := dynamic.NewMessage(md)
+	msg.SetFieldByName("inner", inner("a", 1))
+	msg.SetFieldByName("ints", []int32{1, 2})
+	msg.SetFieldByName("strs", []string{"x", "y"})
+	msg.SetFieldByName("colors", []int32{0, 1, 7})
+	msg.SetFieldByName("inners", []*dynamic.Message{inner("b", 0)})
+	msg.SetFieldByName("counts", map[string]int64{"k": 3})
+	msg.SetFieldByName("byId", map[int32]*dynamic.Message{4: inner("c", 1)})
+	msg.SetFieldByName("other", inner("d", 0))
+	msg.SetFieldByName("maybe", int32(0))
+
+	m := MessageToMap(msg)
+	expected := map[string]any{
+		"inner":  map[string]any{"name": "a", "color": "GREEN"},
+		"ints":   []any{int32(1), int32(2)},
+		"strs":   []any{"x", "y"},
+		"colors": []any{"RED", "GREEN", int32(7)},
+		"inners": []any{map[string]any{"name": "b", "color": "RED"}},
+		"counts": map[string]any{"k": int64(3)},
+		"byId":   map[string]any{"4": map[string]any{"name": "c", "color": "GREEN"}},
+		"text":   nil,
+		"other":  map[string]any{"name": "d", "color": "RED"},
+		"maybe":  int32(0),
+	}
+	for name, want := range expected {
+		if got := m[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", name, got, want)
+		}
+	}
+}
+
+const wellKnownProto = `
+syntax = "proto3";
+package test;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+message Known {
+	google.protobuf.Timestamp at = 1;
+	google.protobuf.Duration took = 2;
+	google.protobuf.Struct attrs = 3;
+	google.protobuf.Int64Value count = 4;
+	google.protobuf.StringValue label = 5;
+	google.protobuf.Any packed = 6;
+	google.protobuf.Any unknown = 7;
+	repeated google.protobuf.Timestamp history = 8;
+}
+`
+
+func TestMessageToMapWellKnownTypes(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"known.proto": wellKnownProto}),
+	}
+	files, err := parser.ParseFiles("known.proto")
+	if err != nil {
+		t.Fatalf("parse known.proto: %v", err)
+	}
+	md := files[0].FindMessage("test.Known")
+	field := func(name string) *desc.MessageDescriptor {
+		return md.FindFieldByName(name).GetMessageType()
+	}
+	newMsg := func(md *desc.MessageDescriptor, fields map[string]any) *dynamic.Message {
+		msg := dynamic.NewMessage(md)
+		for name, value := range fields {
+			msg.SetFieldByName(name, value)
+		}
+		return msg
+	}
+	duration := newMsg(field("took"), map[string]any{"seconds": int64(1), "nanos": int32(500000000)})
+	durationBytes, err := duration.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	structMsg, err := dynamic.AsDynamicMessage(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"name": structpb.NewStringValue("x"),
+		"n":    structpb.NewNumberValue(2),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := dynamic.NewMessage(field("attrs"))
+	if err := attrs.MergeFrom(structMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newMsg(md, map[string]any{
+		"at":    newMsg(field("at"), map[string]any{"seconds": int64(1577836800)}),
+		"took":  duration,
+		"attrs": attrs,
+		"count": newMsg(field("count"), map[string]any{"value": int64(5)}),
+		"label": newMsg(field("label"), map[string]any{"value": "l"}),
+		"packed": newMsg(field("packed"), map[string]any{
+			"type_url": "type.googleapis.com/google.protobuf.Duration",
+			"value":    durationBytes,
+		}),
+		"unknown": newMsg(field("unknown"), map[string]any{
+			"type_url": "type.googleapis.com/test.Missing",
+			"value":    []byte{1, 2},
+		}),
+		"history": []*dynamic.Message{newMsg(field("at"), map[string]any{"seconds": int64(0)})},
+	})
+
+	m := MessageToMap(msg)
+	expected := map[string]any{
+		"at":      "2020-01-01T00:00:00Z",
+		"took":    "1.500s",
+		"attrs":   map[string]any{"name": "x", "n": 2.0},
+		"count":   "5",
+		"label":   "l",
+		"packed":  map[string]any{"@type": "type.googleapis.com/google.protobuf.Duration", "value": "1.500s"},
+		"unknown": map[string]any{"@type": "type.googleapis.com/test.Missing", "value": "AQI="},
+		"history": []any{"1970-01-01T00:00:00Z"},
+	}
+	for name, want := range expected {
+		if got := m[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", name, got, want)
+		}
+	}
+}
+
+func TestStatusDetailsToMaps(t *testing.T) {
+	st, err := status.New(codes.InvalidArgument, "invalid").WithDetails(
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "name", Description: "is empty"},
+		}},
+		&errdetails.ErrorInfo{Reason: "EMPTY", Domain: "test", Metadata: map[string]string{"field": "name"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := st.Proto()
+	proto.Details = append(proto.Details, &anypb.Any{TypeUrl: "type.googleapis.com/test.Missing", Value: []byte{1, 2}})
+
+	details := StatusDetailsToMaps(grpcrunner.TRPCHandler{Status: status.FromProto(proto)})
+	expected := []any{
+		map[string]any{
+			"@type":            "type.googleapis.com/google.rpc.BadRequest",
+			"field_violations": []any{map[string]any{"field": "name", "description": "is empty"}},
+		},
+		map[string]any{
+			"@type":    "type.googleapis.com/google.rpc.ErrorInfo",
+			"reason":   "EMPTY",
+			"domain":   "test",
+			"metadata": map[string]any{"field": "name"},
+		},
+		map[string]any{"@type": "type.googleapis.com/test.Missing", "value": "AQI="},
+	}
+	if !reflect.DeepEqual(details, expected) {
+		t.Errorf("details = %#v, want %#v", details, expected)
+	}
+	if details := StatusDetailsToMaps(grpcrunner.TRPCHandler{}); len(details) != 0 {
+		t.Errorf("details without a status = %#v, want none", details)
+	}
+}